@@ -5,8 +5,13 @@ import (
 	"github.com/clanko/gadget/cmd"
 	"github.com/clanko/gadget/config"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -16,6 +21,29 @@ type builder struct {
 	runningBinary *exec.Cmd
 	debugger      *exec.Cmd
 	port          int
+	// directories of the packages imported by the built package, nil when unknown
+	deps map[string]bool
+	// when the running binary started, and how it exited, empty while it runs
+	startedAt time.Time
+	exitState string
+	// 0 when the debugger isn't running
+	debuggerPid int
+	lastBuild   buildResult
+	// the binary gadget stopped itself, its exit isn't a crash
+	stoppedBinary *exec.Cmd
+	// the address is a free port gadget found, the config has none
+	addressAssigned bool
+	// package and variant picked in the session over the config's
+	chosenPackage string
+	chosenVariant string
+	mu            sync.Mutex
+}
+
+// buildResult is how the last build of a service went.
+type buildResult struct {
+	ok         bool
+	duration   time.Duration
+	finishedAt time.Time
 }
 
 func newBuilder(conf config.Config) builder {
@@ -24,15 +52,29 @@ func newBuilder(conf config.Config) builder {
 	}
 }
 
-func (b *builder) runBuildDebug() {
-	if b.runningBinary != nil && b.runningBinary.Process != nil {
-		cmd.KillPid(b.runningBinary.Process.Pid)
+// newBuilders creates a builder for each service in the config.
+func newBuilders(conf config.Config) []*builder {
+	builders := make([]*builder, 0)
+	for _, serviceConfig := range conf.ServiceConfigs() {
+		b := newBuilder(serviceConfig)
+		builders = append(builders, &b)
 	}
 
-	if b.debugger != nil && b.debugger.Process != nil {
-		cmd.KillPid(b.debugger.Process.Pid)
+	return builders
+}
+
+// name is the service name, or the binary name when there's only one app.
+func (b *builder) name() string {
+	if b.config.ServiceName != "" {
+		return b.config.ServiceName
 	}
 
+	return b.config.Name
+}
+
+func (b *builder) runBuildDebug() {
+	b.stopRunningProcesses()
+
 	err := b.buildBinary()
 	if err != nil {
 		cmd.PrintfDanger("Failed to build binary...")
@@ -40,34 +82,95 @@ func (b *builder) runBuildDebug() {
 		return
 	}
 
-	b.runBinary()
-	b.runDebugger()
+	if b.runBinary() == nil {
+		b.runDebugger()
+	}
 }
 
 func (b *builder) buildBinary() error {
+	sessionLogs.marker(b.name(), "build")
+	sessionLogs.marker(b.debuggerSource(), "build")
+
 	args := []string{"build", "-C=" + b.config.Path, "-o", b.config.Name}
 
-	args = append(args, b.config.BuildArgs...)
+	args = append(args, b.config.BuildFlags()...)
+
+	if b.config.BuildPackage != "" {
+		args = append(args, b.config.BuildPackage)
+	}
 
 	buildCmd := exec.Command("go", args...)
 
+	started := time.Now()
 	output, err := buildCmd.CombinedOutput()
 
-	_ = output
+	b.mu.Lock()
+	b.lastBuild = buildResult{
+		ok:         err == nil,
+		duration:   time.Since(started),
+		finishedAt: time.Now(),
+	}
+	b.mu.Unlock()
 
 	if err != nil {
 		cmd.PrintfDanger("Build: " + err.Error())
 		cmd.PrintfDanger(string(output))
+		events.publish("build_failed", b.name(), string(output))
 
 		return err
 	} else {
 		cmd.PrintfSuccess("Binary built at " + b.config.Path + "/" + b.config.Name)
+		events.publish("build", b.name(), "")
 	}
 
+	// imports may have changed with the code
+	b.loadDeps()
+
 	return nil
 }
 
-func (b *builder) runBinary() {
+// loadDeps lists the directories of every non-standard package the built package imports, so the watcher can tell
+// which services a change affects.
+func (b *builder) loadDeps() {
+	pkg := b.config.BuildPackage
+	if pkg == "" {
+		pkg = "."
+	}
+
+	listCmd := exec.Command("go", "list", "-deps", "-f", "{{if not .Standard}}{{.Dir}}{{end}}", pkg)
+	listCmd.Dir = b.config.Path
+
+	output, err := listCmd.Output()
+	if err != nil {
+		if verbose > 0 {
+			cmd.PrintfWarning("Failed to list imports of %v: %v", b.name(), err)
+		}
+
+		b.deps = nil
+
+		return
+	}
+
+	b.deps = make(map[string]bool)
+	for _, dir := range strings.Split(string(output), "\n") {
+		if dir != "" {
+			b.deps[dir] = true
+		}
+	}
+}
+
+// isAffectedBy reports whether a changed path belongs to one of the packages the service imports. When the imports
+// are unknown every change counts.
+func (b *builder) isAffectedBy(path string) bool {
+	if b.deps == nil {
+		return true
+	}
+
+	return b.deps[path] || b.deps[filepath.Dir(path)]
+}
+
+// runBinary runs the last built binary. It returns the error when the binary can't be started, and nothing runs.
+func (b *builder) runBinary() error {
 	if b.config.Address == "" {
 		freePort, err := b.getListenerPort(8080)
 		if err != nil {
@@ -75,47 +178,138 @@ func (b *builder) runBinary() {
 		}
 
 		b.config.Address = "localhost:" + strconv.Itoa(freePort)
+		b.addressAssigned = true
+	}
+
+	args := append([]string{b.config.Address}, b.config.Args...)
+	if b.config.AddressFlag != "" {
+		args = append([]string{"-" + b.config.AddressFlag, b.config.Address}, b.config.Args...)
 	}
 
-	b.runningBinary = exec.Command(b.config.Path+"/"+b.config.Name, b.config.Address)
-	b.runningBinary.Dir = b.config.Path
-	b.runningBinary.SysProcAttr = &syscall.SysProcAttr{
+	binary := exec.Command(b.config.Path+"/"+b.config.Name, args...)
+	binary.Dir = b.config.Path
+
+	env := b.config.Env
+	if b.config.Variant == "cover" {
+		if dir := b.prepareCoverageDir(); dir != "" {
+			env = append(slices.Clone(env), "GOCOVERDIR="+dir)
+		}
+	}
+
+	if len(env) > 0 {
+		binary.Env = append(os.Environ(), env...)
+	}
+	binary.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
-	stdOut, err := b.runningBinary.StdoutPipe()
+	stdOut, err := binary.StdoutPipe()
 	if err != nil {
 		cmd.PrintfDanger(err.Error())
 	}
 
-	stdErr, err := b.runningBinary.StderrPipe()
+	stdErr, err := binary.StderrPipe()
 	if err != nil {
 		cmd.PrintfDanger(err.Error())
 	}
 
-	err = b.runningBinary.Start()
+	b.mu.Lock()
+	b.runningBinary = binary
+	b.startedAt = time.Now()
+	b.exitState = ""
+	b.mu.Unlock()
+
+	err = binary.Start()
 	if err != nil {
 		cmd.PrintfDanger(err.Error())
-	} else {
-		cmd.PrintfSuccess("Running on http://" + b.config.Address)
+
+		b.mu.Lock()
+		if b.runningBinary == binary {
+			b.runningBinary = nil
+		}
+		b.mu.Unlock()
+
+		return err
 	}
 
-	go printReadCloser(stdOut, func(line string) {
-		print(cmd.FormatSuccess(line))
-	})
-	go printReadCloser(stdErr, func(line string) {
-		print(cmd.FormatDanger(line))
-	})
+	cmd.PrintfSuccess("Running " + b.name() + " on http://" + b.config.Address)
+	events.publish("start", b.name(), b.config.Address)
+
+	output := printOutput(stdOut, stdErr, b.name(), cmd.SourceColor(b.name()))
+
+	go b.waitBinary(binary, output)
+	go b.announceReady(binary)
 
 	if verbose > 0 {
-		cmd.PrintfInfo("Binary pid: " + strconv.Itoa(b.runningBinary.Process.Pid))
+		cmd.PrintfInfo("Binary pid: " + strconv.Itoa(binary.Process.Pid))
 	}
 
 	// Wait for the initial output
 	time.Sleep(1 * time.Second)
+
+	return nil
+}
+
+// waitBinary reports how the running binary ended, unless gadget stopped it.
+func (b *builder) waitBinary(binary *exec.Cmd, output <-chan struct{}) {
+	<-output
+	_ = binary.Wait()
+
+	state := binary.ProcessState
+	if state == nil {
+		return
+	}
+
+	// a config reload can replace the config meanwhile
+	b.mu.Lock()
+	if b.runningBinary == binary {
+		b.exitState = state.String()
+	}
+	stopped := b.stoppedBinary == binary
+	name := b.name()
+	b.mu.Unlock()
+
+	if stopped {
+		events.publish("stop", name, state.String())
+
+		return
+	}
+
+	if state.Success() {
+		cmd.PrintfWarning("%v exited", name)
+		events.publish("exit", name, state.String())
+	} else {
+		cmd.PrintfDanger("%v exited: %v", name, state)
+		events.publish("crash", name, state.String())
+	}
+}
+
+// announceReady publishes a ready event once the binary answers on its address.
+func (b *builder) announceReady(binary *exec.Cmd) {
+	b.mu.Lock()
+	name, address, healthCheck := b.name(), b.config.Address, b.config.HealthCheck
+	b.mu.Unlock()
+
+	if !waitForAddress(address, healthCheck, readyTimeout) {
+		return
+	}
+
+	b.mu.Lock()
+	current := b.runningBinary == binary && b.exitState == ""
+	b.mu.Unlock()
+
+	if current {
+		events.publish("ready", name, address)
+	}
 }
 
 func (b *builder) runDebugger() {
+	if !b.config.Debuggable() {
+		cmd.PrintfInfo("%v runs without a debugger in the %v variant", b.name(), b.config.Variant)
+
+		return
+	}
+
 	if b.port == 0 {
 		port, err := b.getListenerPort(b.config.ListenPort)
 		if err != nil {
@@ -162,14 +356,18 @@ func (b *builder) runDebugger() {
 	err = b.debugger.Start()
 	if err != nil {
 		cmd.PrintfDanger(err.Error())
+
+		return
 	}
 
-	go printReadCloser(debugStdOut, func(line string) {
-		print(cmd.FormatSuccess(line))
-	})
-	go printReadCloser(debugStdErr, func(line string) {
-		print(cmd.FormatDanger(line))
-	})
+	b.mu.Lock()
+	b.debuggerPid = b.debugger.Process.Pid
+	b.mu.Unlock()
+
+	source := b.debuggerSource()
+	output := printOutput(debugStdOut, debugStdErr, source, cmd.SourceColor(source))
+
+	go b.waitDebugger(b.debugger, output)
 
 	// Wait for the initial output from running the debugger
 	time.Sleep(1 * time.Second)
@@ -179,6 +377,29 @@ func (b *builder) runDebugger() {
 	}
 }
 
+// waitDebugger reaps the debugger once it exits and its output is printed.
+func (b *builder) waitDebugger(debugger *exec.Cmd, output <-chan struct{}) {
+	<-output
+	_ = debugger.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the debugger died by itself
+	if b.debuggerPid == debugger.Process.Pid {
+		b.debuggerPid = 0
+	}
+}
+
+// debuggerSource names the debugger's output, dlv for a single app or dlv:{service} for services.
+func (b *builder) debuggerSource() string {
+	if b.config.ServiceName != "" {
+		return "dlv:" + b.config.ServiceName
+	}
+
+	return "dlv"
+}
+
 func (b *builder) getListenerPort(preferredPort int) (port int, err error) {
 	listenPreferred, err := net.Listen("tcp", ":"+strconv.Itoa(preferredPort))
 	if err != nil {
@@ -222,6 +443,20 @@ func (b *builder) waitForPortFree() bool {
 }
 
 func (b *builder) stopRunningProcesses() {
+	b.mu.Lock()
+	b.stoppedBinary = b.runningBinary
+	b.debuggerPid = 0
+	b.mu.Unlock()
+
+	// coverage data is only written when the app exits by itself
+	if b.config.Variant == "cover" && b.isRunning() {
+		if b.debugger != nil && b.debugger.Process != nil {
+			cmd.KillPid(b.debugger.Process.Pid)
+		}
+
+		b.interruptBinary()
+	}
+
 	if b.runningBinary != nil && b.runningBinary.Process != nil {
 		cmd.KillPid(b.runningBinary.Process.Pid)
 	}
@@ -230,3 +465,17 @@ func (b *builder) stopRunningProcesses() {
 		cmd.KillPid(b.debugger.Process.Pid)
 	}
 }
+
+// interruptBinary sends the running binary an interrupt and waits a moment for it to exit.
+func (b *builder) interruptBinary() {
+	cmd.InterruptPid(b.runningBinary.Process.Pid)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for b.isRunning() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if b.isRunning() {
+		cmd.PrintfWarning("%v didn't exit after an interrupt, its coverage data of this run is lost", b.name())
+	}
+}
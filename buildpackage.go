@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listMainPackages lists the main packages matching pattern in dir, relative to it like ./cmd/api, or . for dir
+// itself.
+func listMainPackages(dir string, pattern string) ([]string, error) {
+	output, err := goCommand(dir, "list", "-f", "{{if eq .Name \"main\"}}{{.Dir}}{{end}}", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%v", strings.TrimSpace(string(output)))
+	}
+
+	packages := make([]string, 0)
+	for _, packageDir := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		relative, err := filepath.Rel(dir, packageDir)
+		if packageDir == "" || err != nil {
+			continue
+		}
+
+		if relative == "." {
+			packages = append(packages, ".")
+		} else {
+			packages = append(packages, "./"+filepath.ToSlash(relative))
+		}
+	}
+
+	return packages, nil
+}
+
+// pickBuildPackages chooses the package of each service whose build_package doesn't name a single main package:
+// one that isn't set while the project's directory isn't a main package, or a pattern like ./cmd/... . With several
+// candidates, it asks.
+func (gsh *gadgetShell) pickBuildPackages(input lineReader) {
+	for _, builder := range gsh.builders {
+		pattern := builder.config.BuildPackage
+		if pattern == "" {
+			pattern = "."
+		} else if !strings.Contains(pattern, "...") {
+			continue
+		}
+
+		packages, err := listMainPackages(builder.config.Path, pattern)
+		if err == nil && len(packages) == 1 && packages[0] == pattern {
+			continue
+		}
+
+		// the project's directory isn't a main package, look for one in the whole module
+		if pattern == "." && (err != nil || len(packages) == 0) {
+			packages, err = listMainPackages(builder.config.Path, "./...")
+		}
+
+		if err != nil {
+			continue
+		}
+
+		switch len(packages) {
+		case 0:
+			cmd.PrintfWarning("No main package found for %v, set build_package in %v", builder.name(), configFile)
+
+		case 1:
+			cmd.PrintfInfo("Building %v for %v, the only main package", packages[0], builder.name())
+			builder.choosePackage(packages[0])
+
+		default:
+			builder.choosePackage(pickPackage(builder.name(), packages, input))
+		}
+	}
+}
+
+// pickPackage asks which of the packages to build. Without an answer, it's the first.
+func pickPackage(name string, packages []string, input lineReader) string {
+	cmd.PrintfInfo("Main packages for %v:", name)
+
+	for i, pkg := range packages {
+		cmd.Write(fmt.Sprintf("  %v) %v\n", i+1, pkg))
+	}
+
+	for {
+		answer, err := input.readLine(cmd.FormatInfo(fmt.Sprintf("Build which package? [1-%v] ", len(packages))))
+		if err != nil {
+			cmd.PrintfWarning("Building %v", packages[0])
+
+			return packages[0]
+		}
+
+		answer = strings.TrimSpace(answer)
+
+		if number, err := strconv.Atoi(answer); err == nil && number >= 1 && number <= len(packages) {
+			return packages[number-1]
+		}
+
+		for _, pkg := range packages {
+			if answer == pkg || "./"+answer == pkg {
+				return pkg
+			}
+		}
+
+		cmd.PrintfWarning("Enter a number from 1 to %v, or a package", len(packages))
+	}
+}
+
+// choosePackage builds another package than the config's for the rest of the session, or until the config's
+// build_package changes. Its imports are loaded again with the next build.
+func (b *builder) choosePackage(pkg string) {
+	b.config.BuildPackage = pkg
+	b.chosenPackage = pkg
+	b.deps = nil
+}
+
+// switchBuildPackage makes the builder build pkg, when it's a main package. A pattern matching several asks which.
+func switchBuildPackage(builder *builder, pkg string, input lineReader) bool {
+	packages, err := listMainPackages(builder.config.Path, pkg)
+	if err != nil || len(packages) == 0 {
+		cmd.PrintfWarning("%v isn't a main package of %v", pkg, builder.config.Path)
+
+		if all, err := listMainPackages(builder.config.Path, "./..."); err == nil && len(all) > 0 {
+			cmd.PrintfInfo("Main packages: %v", strings.Join(all, ", "))
+		}
+
+		return false
+	}
+
+	if len(packages) > 1 {
+		builder.choosePackage(pickPackage(builder.name(), packages, input))
+	} else {
+		builder.choosePackage(packages[0])
+	}
+
+	cmd.PrintfInfo("Building %v until build_package changes in %v", builder.config.BuildPackage, filepath.Base(configFile))
+
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListMainPackages(t *testing.T) {
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"go.mod":             "module example.com/shop\n\ngo 1.21\n",
+		"cmd/api/main.go":    "package main\n\nfunc main() {}\n",
+		"cmd/worker/main.go": "package main\n\nfunc main() {}\n",
+		"internal/db/db.go":  "package db\n",
+	}
+
+	writeTestFiles(t, dir, files)
+
+	packages, err := listMainPackages(dir, "./...")
+	if err != nil || !reflect.DeepEqual(packages, []string{"./cmd/api", "./cmd/worker"}) {
+		t.Errorf("Expected both main packages, got %v, %v", packages, err)
+	}
+
+	packages, err = listMainPackages(dir, "./internal/db")
+	if err != nil || len(packages) != 0 {
+		t.Errorf("Expected no main package, got %v, %v", packages, err)
+	}
+}
+
+func TestAffectedBuilders(t *testing.T) {
+	api := &builder{deps: map[string]bool{"/shop/cmd/api": true, "/shop/internal/db": true}}
+	builders := []*builder{api}
+
+	if affected := affectedBuilders(builders, []string{"/shop/internal/db/db.go"}); len(affected) != 1 {
+		t.Errorf("Expected a change to an imported package to rebuild the service")
+	}
+
+	if affected := affectedBuilders(builders, []string{"/shop/cmd/worker/main.go"}); len(affected) != 0 {
+		t.Errorf("Expected a change to another main package to rebuild nothing")
+	}
+
+	if affected := affectedBuilders(builders, []string{"/shop/web/app.css"}); len(affected) != 1 {
+		t.Errorf("Expected an asset change to rebuild every service")
+	}
+}
+
+func TestChangedBuildersReloadsImports(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// imports listed before the app started importing the config package
+	app := &builder{config: config.Config{Path: dir}, deps: map[string]bool{dir: true}}
+
+	changed := []string{filepath.Join(dir, "config", "config.go")}
+	if affected := changedBuilders([]*builder{app}, changed); len(affected) != 1 {
+		t.Errorf("Expected a change to a newly imported package to rebuild the app")
+	}
+
+	changed = []string{filepath.Join(dir, "_testdata", "unused.go")}
+	if affected := changedBuilders([]*builder{app}, changed); len(affected) != 0 {
+		t.Errorf("Expected a change to a package the app doesn't import to rebuild nothing")
+	}
+}
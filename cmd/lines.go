@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// LineFormat is how lines printed by the app, the debugger and other processes are shown.
+type LineFormat struct {
+	// prefix lines with their source, e.g. [api] or [dlv]
+	Prefix bool
+	// prefix lines with the time they were printed, to the millisecond
+	Timestamps bool
+	// paint stderr lines red
+	ColorStderr bool
+}
+
+// colors handed out to sources. Red is left out, it's for errors.
+var sourceColors = []string{COLOR_SUCCESS, COLOR_INFO, COLOR_MAGENTA, COLOR_BLUE, COLOR_YELLOW}
+
+// SourceColor picks a color for a source. The same source always gets the same color.
+func SourceColor(source string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(source))
+
+	return sourceColors[hash.Sum32()%uint32(len(sourceColors))]
+}
+
+// FormatLine formats a line printed by source. With a prefix, the prefix gets the source's color, otherwise the line
+// does.
+func (format LineFormat) FormatLine(source string, color string, isStderr bool, line string, printedAt time.Time) string {
+	line = strings.TrimRight(line, "\r\n")
+
+	formatted := ""
+	if format.Timestamps {
+		formatted = printedAt.Format("15:04:05.000") + " "
+	}
+
+	textColor := color
+	if format.Prefix {
+		formatted += color + "[" + source + "]" + COLOR_RESET + " "
+		textColor = ""
+	}
+
+	if isStderr && format.ColorStderr {
+		textColor = COLOR_DANGER
+	}
+
+	if textColor == "" {
+		return formatted + line + "\n"
+	}
+
+	return formatted + textColor + line + COLOR_RESET + "\n"
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatLine(t *testing.T) {
+	printedAt := time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC)
+
+	format := LineFormat{Prefix: true, Timestamps: true}
+
+	line := format.FormatLine("api", COLOR_INFO, true, "listening\n", printedAt)
+	expected := "15:04:05.123 " + COLOR_INFO + "[api]" + COLOR_RESET + " listening\n"
+	if line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+
+	format = LineFormat{ColorStderr: true}
+
+	line = format.FormatLine("api", COLOR_INFO, true, "failed", printedAt)
+	expected = COLOR_DANGER + "failed" + COLOR_RESET + "\n"
+	if line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+
+	line = format.FormatLine("api", COLOR_INFO, false, "ok", printedAt)
+	expected = COLOR_INFO + "ok" + COLOR_RESET + "\n"
+	if line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+}
+
+func TestSourceColor(t *testing.T) {
+	if SourceColor("api") != SourceColor("api") {
+		t.Errorf("Expected the same source to get the same color")
+	}
+
+	for _, source := range []string{"api", "worker", "dlv", "frontend", "hook:generate"} {
+		if SourceColor(source) == COLOR_DANGER {
+			t.Errorf("Expected %v not to be colored like an error", source)
+		}
+	}
+}
+
+func TestStripColors(t *testing.T) {
+	colored := FormatDanger("failed") + " " + FormatWithColor("\033[1;34m", "[api]")
+	if StripColors(colored) != "failed [api]" {
+		t.Errorf("Expected colors to be removed, got %q", StripColors(colored))
+	}
+}
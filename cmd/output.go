@@ -1,6 +1,10 @@
 package cmd
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
 
 const (
 	COLOR_RESET   = "\u001B[0m"
@@ -8,10 +12,28 @@ const (
 	COLOR_SUCCESS = "\033[32m"
 	COLOR_INFO    = "\033[36m"
 	COLOR_WARNING = "\033[0;33m"
+	COLOR_YELLOW  = "\033[33m"
+	COLOR_BLUE    = "\033[34m"
+	COLOR_MAGENTA = "\033[35m"
 )
 
+// NamedColors are the colors that can be picked by name in gadget.toml.
+var NamedColors = map[string]string{
+	"red":     COLOR_DANGER,
+	"green":   COLOR_SUCCESS,
+	"yellow":  COLOR_YELLOW,
+	"blue":    COLOR_BLUE,
+	"magenta": COLOR_MAGENTA,
+	"cyan":    COLOR_INFO,
+}
+
+// Write prints text for the Printf functions. It can be replaced to coordinate output with an interactive prompt.
+var Write = func(text string) {
+	_, _ = fmt.Fprint(os.Stderr, text)
+}
+
 func PrintfSuccess(text string, vars ...any) {
-	println(FormatSuccess(text, vars...))
+	Write(FormatSuccess(text, vars...) + "\n")
 }
 
 func FormatSuccess(text string, vars ...any) string {
@@ -19,7 +41,7 @@ func FormatSuccess(text string, vars ...any) string {
 }
 
 func PrintfInfo(text string, vars ...any) {
-	println(FormatInfo(text, vars...))
+	Write(FormatInfo(text, vars...) + "\n")
 }
 
 func FormatInfo(text string, vars ...any) string {
@@ -27,7 +49,7 @@ func FormatInfo(text string, vars ...any) string {
 }
 
 func PrintfWarning(text string, vars ...any) {
-	println(FormatWarning(text, vars...))
+	Write(FormatWarning(text, vars...) + "\n")
 }
 
 func FormatWarning(text string, vars ...any) string {
@@ -35,7 +57,7 @@ func FormatWarning(text string, vars ...any) string {
 }
 
 func PrintfDanger(text string, vars ...any) {
-	println(FormatDanger(text, vars...))
+	Write(FormatDanger(text, vars...) + "\n")
 }
 
 func FormatDanger(text string, vars ...any) string {
@@ -45,3 +67,10 @@ func FormatDanger(text string, vars ...any) string {
 func FormatWithColor(color, text string, vars ...any) string {
 	return fmt.Sprintf(color+text+COLOR_RESET, vars...)
 }
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]")
+
+// StripColors removes ANSI escape sequences, like colors, from text.
+func StripColors(text string) string {
+	return ansiEscape.ReplaceAllString(text, "")
+}
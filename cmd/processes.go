@@ -14,3 +14,15 @@ func KillPid(pid int) {
 		}
 	}
 }
+
+// InterruptPid sends an interrupt to the process group of pid, so it can shut down by itself.
+func InterruptPid(pid int) {
+	pgid, err := syscall.Getpgid(pid)
+	if err == nil {
+		err := syscall.Kill(-pgid, syscall.SIGINT)
+
+		if err != nil {
+			PrintfDanger(err.Error())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// lines longer than this are passed on in pieces, so a runaway line can't take all the memory
+const maxLineLength = 1 << 20
+
+// ReadLines calls onLine with every line read from reader, including its newline, until reader is closed. A partial
+// line, like a prompt printed by the app, is passed on without a newline once nothing more arrived for flushAfter.
+func ReadLines(reader io.Reader, flushAfter time.Duration, onLine func(string)) {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := reader.Read(buffer)
+			if n > 0 {
+				chunks <- bytes.Clone(buffer[:n])
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	flush := time.NewTimer(flushAfter)
+	flush.Stop()
+	defer flush.Stop()
+
+	var pending []byte
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if len(pending) > 0 {
+					onLine(string(pending))
+				}
+
+				return
+			}
+
+			pending = append(pending, chunk...)
+
+			for {
+				end := bytes.IndexByte(pending, '\n')
+				if end < 0 {
+					break
+				}
+
+				onLine(string(pending[:end+1]))
+				pending = pending[end+1:]
+			}
+
+			for len(pending) >= maxLineLength {
+				onLine(string(pending[:maxLineLength]))
+				pending = pending[maxLineLength:]
+			}
+
+			if len(pending) > 0 {
+				flush.Reset(flushAfter)
+			} else {
+				flush.Stop()
+			}
+
+		case <-flush.C:
+			if len(pending) > 0 {
+				onLine(string(pending))
+				pending = nil
+			}
+		}
+	}
+}
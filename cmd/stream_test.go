@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadLines(t *testing.T) {
+	long := strings.Repeat("a", 100*1024)
+
+	lines := make([]string, 0)
+	ReadLines(strings.NewReader("first\nsecond\n"+long+"\nlast"), time.Second, func(line string) {
+		lines = append(lines, line)
+	})
+
+	expected := []string{"first\n", "second\n", long + "\n", "last"}
+	if reflect.DeepEqual(lines, expected) == false {
+		t.Errorf("Expected every line, got %v lines", len(lines))
+	}
+}
+
+func TestReadLinesFlushesPartialLine(t *testing.T) {
+	reader, writer := io.Pipe()
+
+	var mu sync.Mutex
+	lines := make([]string, 0)
+	done := make(chan struct{})
+	go func() {
+		ReadLines(reader, 20*time.Millisecond, func(line string) {
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+		})
+		close(done)
+	}()
+
+	_, _ = writer.Write([]byte("Enter a value: "))
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	flushed := reflect.DeepEqual(lines, []string{"Enter a value: "})
+	mu.Unlock()
+
+	if !flushed {
+		t.Errorf("Expected the partial line to be flushed, got %v", lines)
+	}
+
+	_ = writer.Close()
+	<-done
+}
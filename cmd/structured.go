@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// LogFormatter renders structured log lines, JSON like log/slog's JSON handler or logfmt like its text handler, as
+// readable lines colored by level.
+type LogFormatter struct {
+	// lines below this level aren't shown. Lines without a level are always shown
+	MinLevel string
+	// attributes that aren't shown
+	HideKeys []string
+}
+
+type logField struct {
+	key   string
+	value string
+	// whether value was a string, so it might need quoting
+	isString bool
+}
+
+var levelRanks = map[string]int{
+	"TRACE":   0,
+	"DEBUG":   1,
+	"INFO":    2,
+	"WARN":    3,
+	"WARNING": 3,
+	"ERROR":   4,
+	"FATAL":   5,
+	"PANIC":   5,
+}
+
+var levelColors = map[int]string{
+	0: COLOR_BLUE,
+	1: COLOR_BLUE,
+	2: COLOR_SUCCESS,
+	3: COLOR_YELLOW,
+	4: COLOR_DANGER,
+	5: COLOR_DANGER,
+}
+
+// Format renders line when it's structured, otherwise it's returned unchanged. The bool is false when the line is
+// below the minimum level and shouldn't be shown.
+func (formatter LogFormatter) Format(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+
+	var fields []logField
+	var err error
+	if strings.HasPrefix(trimmed, "{") {
+		fields, err = parseJSONLine([]byte(trimmed))
+	} else {
+		fields, err = parseLogfmtLine(trimmed)
+	}
+
+	if err != nil {
+		return line, true
+	}
+
+	var timeValue, level, message string
+	attributes := make([]logField, 0, len(fields))
+	for _, field := range fields {
+		switch {
+		case timeValue == "" && (field.key == "time" || field.key == "ts"):
+			timeValue = field.value
+		case level == "" && (field.key == "level" || field.key == "lvl"):
+			level = strings.ToUpper(field.value)
+		case message == "" && (field.key == "msg" || field.key == "message"):
+			message = field.value
+		default:
+			if !formatter.isHidden(field.key) {
+				attributes = append(attributes, field)
+			}
+		}
+	}
+
+	// logfmt needs a level or a message to tell it apart from plain text with an = in it
+	if level == "" && message == "" {
+		return line, true
+	}
+
+	rank, hasRank := levelRank(level)
+	if minRank, ok := levelRank(formatter.MinLevel); ok && hasRank && rank < minRank {
+		return "", false
+	}
+
+	formatted := make([]string, 0, len(attributes)+3)
+	if timeValue != "" {
+		formatted = append(formatted, formatLogTime(timeValue))
+	}
+
+	if level != "" {
+		color, ok := levelColors[rank]
+		if !hasRank || !ok {
+			color = COLOR_RESET
+		}
+
+		formatted = append(formatted, FormatWithColor(color, "%-5s", level))
+	}
+
+	if message != "" {
+		formatted = append(formatted, message)
+	}
+
+	for _, attribute := range attributes {
+		value := attribute.value
+		if attribute.isString && (value == "" || strings.IndexFunc(value, needsQuote) >= 0) {
+			value = quote(value)
+		}
+
+		formatted = append(formatted, FormatWithColor(COLOR_INFO, "%v=", attribute.key)+value)
+	}
+
+	return strings.Join(formatted, " ") + "\n", true
+}
+
+func (formatter LogFormatter) isHidden(key string) bool {
+	for _, hidden := range formatter.HideKeys {
+		if hidden == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// levelRank ranks a level name. slog's levels between the named ones, like INFO+2, rank with the level they're
+// based on.
+func levelRank(level string) (int, bool) {
+	level = strings.ToUpper(level)
+	if i := strings.IndexAny(level, "+-"); i > 0 {
+		level = level[:i]
+	}
+
+	rank, ok := levelRanks[level]
+
+	return rank, ok
+}
+
+// formatLogTime shows RFC 3339 times in local time to the millisecond. Anything else is shown as it was logged.
+func formatLogTime(value string) string {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+
+	return parsed.Local().Format("15:04:05.000")
+}
+
+func needsQuote(r rune) bool {
+	return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+}
+
+func quote(value string) string {
+	quoted, _ := json.Marshal(value)
+
+	return string(quoted)
+}
+
+// parseJSONLine reads the fields of a JSON object in the order they were logged. Nested objects, like slog groups,
+// are flattened into group.key fields.
+func parseJSONLine(line []byte) ([]logField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	fields, err := parseJSONObject(decoder, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if decoder.More() {
+		return nil, errors.New("unexpected content after JSON object")
+	}
+
+	return fields, nil
+}
+
+func parseJSONObject(decoder *json.Decoder, prefix string) ([]logField, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, errors.New("not a JSON object")
+	}
+
+	fields := make([]logField, 0)
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := token.(string)
+		if !ok {
+			return nil, errors.New("expected an object key")
+		}
+
+		var raw json.RawMessage
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = bytes.TrimSpace(raw)
+
+		switch {
+		case len(raw) > 0 && raw[0] == '{':
+			nested, err := parseJSONObject(json.NewDecoder(bytes.NewReader(raw)), prefix+key+".")
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, nested...)
+		case len(raw) > 0 && raw[0] == '"':
+			var value string
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, logField{key: prefix + key, value: value, isString: true})
+		default:
+			fields = append(fields, logField{key: prefix + key, value: string(raw)})
+		}
+	}
+
+	// closing brace
+	_, err = decoder.Token()
+
+	return fields, err
+}
+
+// parseLogfmtLine reads key=value pairs. Values may be quoted. Anything that isn't a key=value pair means the line
+// isn't logfmt.
+func parseLogfmtLine(line string) ([]logField, error) {
+	fields := make([]logField, 0)
+
+	for line != "" {
+		equals := strings.IndexByte(line, '=')
+		if equals <= 0 {
+			return nil, errors.New("expected key=value")
+		}
+
+		key := line[:equals]
+		if strings.IndexFunc(key, needsQuote) >= 0 {
+			return nil, errors.New("invalid key")
+		}
+
+		line = line[equals+1:]
+
+		field := logField{key: key, isString: true}
+		if strings.HasPrefix(line, `"`) {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(line) {
+				return nil, errors.New("unterminated quote")
+			}
+
+			err := json.Unmarshal([]byte(line[:end+1]), &field.value)
+			if err != nil {
+				return nil, err
+			}
+
+			line = line[end+1:]
+		} else {
+			end := strings.IndexByte(line, ' ')
+			if end < 0 {
+				end = len(line)
+			}
+
+			field.value = line[:end]
+			line = line[end:]
+		}
+
+		if line != "" && line[0] != ' ' {
+			return nil, errors.New("expected a space between pairs")
+		}
+
+		line = strings.TrimLeft(line, " ")
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("no fields")
+	}
+
+	return fields, nil
+}
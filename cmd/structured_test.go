@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogFormatterJSON(t *testing.T) {
+	formatter := LogFormatter{HideKeys: []string{"request_id"}}
+
+	line := `{"time":"2024-01-02T15:04:05.123Z","level":"WARN","msg":"slow query","duration":1.5,"request_id":"abc","db":{"table":"users"},"query":"select 1"}`
+
+	formatted, show := formatter.Format(line)
+	if !show {
+		t.Fatalf("Expected the line to be shown")
+	}
+
+	for _, expected := range []string{"WARN", "slow query", "duration=" + COLOR_RESET + "1.5", "db.table=" + COLOR_RESET + "users", `query=` + COLOR_RESET + `"select 1"`} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("Expected %q in %q", expected, formatted)
+		}
+	}
+
+	if strings.Contains(formatted, "request_id") {
+		t.Errorf("Expected request_id to be hidden in %q", formatted)
+	}
+
+	if strings.Index(formatted, "duration") > strings.Index(formatted, "query=") {
+		t.Errorf("Expected attributes in the order they were logged in %q", formatted)
+	}
+}
+
+func TestLogFormatterLogfmt(t *testing.T) {
+	formatter := LogFormatter{}
+
+	formatted, show := formatter.Format(`time=2024-01-02T15:04:05Z level=INFO msg="server started" addr=:8080`)
+	if !show || !strings.Contains(formatted, "server started") || !strings.Contains(formatted, "addr="+COLOR_RESET+":8080") {
+		t.Errorf("Unexpected logfmt formatting %q", formatted)
+	}
+
+	plain := "listening on a=b but not logfmt\n"
+
+	formatted, show = formatter.Format(plain)
+	if !show || formatted != plain {
+		t.Errorf("Expected plain text unchanged, got %q", formatted)
+	}
+}
+
+func TestLogFormatterMinLevel(t *testing.T) {
+	formatter := LogFormatter{MinLevel: "info"}
+
+	_, show := formatter.Format(`{"level":"DEBUG","msg":"noisy"}`)
+	if show {
+		t.Errorf("Expected debug line to be hidden")
+	}
+
+	_, show = formatter.Format(`{"level":"INFO+2","msg":"shown"}`)
+	if !show {
+		t.Errorf("Expected INFO+2 line to be shown")
+	}
+
+	_, show = formatter.Format("not structured")
+	if !show {
+		t.Errorf("Expected unstructured line to be shown")
+	}
+}
@@ -1,22 +1,62 @@
 package main
 
 import (
-	"bufio"
+	"fmt"
 	"github.com/clanko/gadget/cmd"
-	"github.com/clanko/gadget/config"
 	"github.com/clanko/scaffold"
 	"os"
+	"sort"
 )
 
 type command interface {
-	execute(input *bufio.Scanner, args []string)
+	info() commandInfo
+	execute(input lineReader, args []string)
+}
+
+// commandInfo describes a command for help, completion and argument validation.
+type commandInfo struct {
+	name     string
+	synopsis string
+	// arguments as shown in usage, e.g. "[service...]"
+	args string
+	// more about the arguments, shown by help <command>
+	details string
+	minArgs int
+	// -1 for any number of arguments
+	maxArgs int
+}
+
+func (info commandInfo) usage() string {
+	if info.args == "" {
+		return info.name
+	}
+
+	return info.name + " " + info.args
+}
+
+// validateArgs returns a usage error when the number of arguments doesn't fit the command.
+func (info commandInfo) validateArgs(args []string) error {
+	if len(args) < info.minArgs || (info.maxArgs >= 0 && len(args) > info.maxArgs) {
+		return fmt.Errorf("usage: %v", info.usage())
+	}
+
+	return nil
 }
 
 type watchCommand struct {
 	gsh *gadgetShell
 }
 
-func (command watchCommand) execute(input *bufio.Scanner, args []string) {
+func (command watchCommand) info() commandInfo {
+	return commandInfo{
+		name:     "watch",
+		synopsis: "Starts the file watcher",
+		minArgs:  0,
+		maxArgs:  0,
+	}
+}
+
+func (command watchCommand) execute(input lineReader, args []string) {
 	if command.gsh.watcher != nil && command.gsh.watcher.isWatching {
 		cmd.PrintfWarning("Watcher already watching")
 
@@ -29,14 +69,23 @@ func (command watchCommand) execute(input *bufio.Scanner, args []string) {
 
 	cmd.PrintfInfo("Watching files")
 
-	runWatcher(command.gsh.watcher, command.gsh.builder)
+	runWatcher(command.gsh.watcher, command.gsh)
 }
 
 type unwatchCommand struct {
 	gsh *gadgetShell
 }
 
-func (command unwatchCommand) execute(input *bufio.Scanner, args []string) {
+func (command unwatchCommand) info() commandInfo {
+	return commandInfo{
+		name:     "unwatch",
+		synopsis: "Stops the file watcher",
+		minArgs:  0,
+		maxArgs:  0,
+	}
+}
+
+func (command unwatchCommand) execute(input lineReader, args []string) {
 	if command.gsh.watcher != nil && command.gsh.watcher.isWatching {
 		command.gsh.watcher.endWatch()
 
@@ -48,14 +97,25 @@ type devCommand struct {
 	gsh *gadgetShell
 }
 
-func (command devCommand) execute(input *bufio.Scanner, args []string) {
+func (command devCommand) info() commandInfo {
+	return commandInfo{
+		name:     "dev",
+		synopsis: "Builds and runs services with the debugger, and watches files",
+		args:     "[name...]",
+		details:  "Without names, starts every service and process.",
+		minArgs:  0,
+		maxArgs:  -1,
+	}
+}
+
+func (command devCommand) execute(input lineReader, args []string) {
 	if command.gsh.watcher != nil && command.gsh.watcher.isWatching {
 		command.gsh.watcher.endWatch()
 
 		command.gsh.watcher = nil
 	}
 
-	command.gsh.builder.runBuildDebug()
+	startUnits(command.gsh.units, command.gsh.selectUnits(args))
 
 	watcher := newWatcher(command.gsh.config)
 
@@ -63,49 +123,190 @@ func (command devCommand) execute(input *bufio.Scanner, args []string) {
 
 	cmd.PrintfInfo("Watching files")
 
-	runWatcher(command.gsh.watcher, command.gsh.builder)
+	runWatcher(command.gsh.watcher, command.gsh)
 }
 
 type debugCommand struct {
 	gsh *gadgetShell
 }
 
-func (command debugCommand) execute(input *bufio.Scanner, args []string) {
-	command.gsh.builder.runBuildDebug()
+func (command debugCommand) info() commandInfo {
+	return commandInfo{
+		name:     "debug",
+		synopsis: "Builds and runs services with the debugger",
+		args:     "[name...]",
+		details:  "Without names, starts every service and process.",
+		minArgs:  0,
+		maxArgs:  -1,
+	}
+}
+
+func (command debugCommand) execute(input lineReader, args []string) {
+	startUnits(command.gsh.units, command.gsh.selectUnits(args))
 }
 
 type runCommand struct {
 	gsh *gadgetShell
 }
 
-func (command runCommand) execute(input *bufio.Scanner, args []string) {
-	command.gsh.builder.stopRunningProcesses()
-
-	err := command.gsh.builder.buildBinary()
-	if err != nil {
-		cmd.PrintfDanger("%v", err)
+func (command runCommand) info() commandInfo {
+	return commandInfo{
+		name:     "run",
+		synopsis: "Builds and runs services without the debugger",
+		args:     "[service...]",
+		details:  "Without names, runs every service.",
+		minArgs:  0,
+		maxArgs:  -1,
 	}
+}
 
-	command.gsh.builder.runBinary()
+func (command runCommand) execute(input lineReader, args []string) {
+	for _, builder := range command.gsh.selectBuilders(args) {
+		builder.stopRunningProcesses()
+
+		err := builder.buildBinary()
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+		}
+
+		_ = builder.runBinary()
+	}
 }
 
 type buildCommand struct {
 	gsh *gadgetShell
 }
 
-func (command buildCommand) execute(input *bufio.Scanner, args []string) {
-	command.gsh.builder.stopRunningProcesses()
+func (command buildCommand) info() commandInfo {
+	return commandInfo{
+		name:     "build",
+		synopsis: "Builds service binaries",
+		args:     "[service... | package]",
+		details: "Stops the running binaries and debuggers first. Without names, builds every service. Without services, " +
+			"a package like ./cmd/worker builds that main package from then on, a pattern like ./cmd/... asks which.",
+		minArgs: 0,
+		maxArgs: -1,
+	}
+}
 
-	err := command.gsh.builder.buildBinary()
-	if err != nil {
-		cmd.PrintfDanger("%v", err)
+func (command buildCommand) execute(input lineReader, args []string) {
+	builders := command.gsh.builders
+
+	// with one app, an argument that isn't its name is the package to build
+	if len(args) == 1 && len(builders) == 1 && builders[0].name() != args[0] {
+		if !switchBuildPackage(builders[0], args[0], input) {
+			return
+		}
+
+		args = nil
+	}
+
+	for _, builder := range command.gsh.selectBuilders(args) {
+		builder.stopRunningProcesses()
+
+		err := builder.buildBinary()
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+		}
+	}
+}
+
+type restartCommand struct {
+	gsh *gadgetShell
+}
+
+func (command restartCommand) info() commandInfo {
+	return commandInfo{
+		name:     "restart",
+		synopsis: "Restarts services and processes without rebuilding",
+		args:     "[name...]",
+		details:  "Without names, restarts every service and process.",
+		minArgs:  0,
+		maxArgs:  -1,
 	}
 }
 
+func (command restartCommand) execute(input lineReader, args []string) {
+	for _, unit := range command.gsh.selectUnits(args) {
+		switch unit := unit.(type) {
+		case *builder:
+			unit.restart()
+		case *auxProcess:
+			unit.restart()
+		}
+	}
+}
+
+type helpCommand struct {
+	gsh *gadgetShell
+}
+
+func (command helpCommand) info() commandInfo {
+	return commandInfo{
+		name:     "help",
+		synopsis: "Lists commands, or shows how to use one",
+		args:     "[command]",
+		minArgs:  0,
+		maxArgs:  1,
+	}
+}
+
+func (command helpCommand) execute(input lineReader, args []string) {
+	commands := command.gsh.getCommands()
+
+	if len(args) == 1 {
+		registered, ok := commands[args[0]]
+		if !ok {
+			cmd.PrintfWarning("Unknown command: %v", args[0])
+
+			return
+		}
+
+		info := registered.info()
+
+		cmd.PrintfInfo("Usage: %v", info.usage())
+		cmd.Write(info.synopsis + "\n")
+		if info.details != "" {
+			cmd.Write(info.details + "\n")
+		}
+
+		return
+	}
+
+	names := make([]string, 0, len(commands))
+	width := 0
+	for name, registered := range commands {
+		names = append(names, name)
+		width = max(width, len(registered.info().usage()))
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := commands[name].info()
+		cmd.Write(cmd.FormatInfo("%-*v", width, info.usage()) + "  " + info.synopsis + "\n")
+	}
+
+	cmd.Write("Anything else is run as a shell command. Enter \"help <command>\" for more about a command.\n")
+}
+
 type makeCommand struct {
 }
 
-func (make makeCommand) execute(input *bufio.Scanner, args []string) {
+func (make makeCommand) info() commandInfo {
+	return commandInfo{
+		name:     "make",
+		synopsis: "Generates files from a template",
+		args:     "<template>",
+		details: "make gadget-config generates a gadget.toml in the current directory from the project's main packages, " +
+			"asking about each finding, or using them all with --auto. --sample writes a sample with every setting instead. " +
+			"Other templates are folders in ~/.clanko-gadget-cli/make-templates.",
+		minArgs: 1,
+		maxArgs: 2,
+	}
+}
+
+func (make makeCommand) execute(input lineReader, args []string) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
@@ -127,34 +328,18 @@ func (make makeCommand) execute(input *bufio.Scanner, args []string) {
 	}
 
 	if path == "gadget-config" {
-		gadgetConfigFile := wd + "/gadget.toml"
-		// make sure there's not already a gadget.toml
-		_, err = os.Stat(gadgetConfigFile)
-		if err == nil {
-			cmd.PrintfInfo("It looks like there's already a gadget.toml here. Remove or rename it before generating another")
-
-			return
+		mode := ""
+		if len(args) > 1 {
+			mode = args[1]
 		}
 
-		// generate a gadget.toml in the current directory
-		newFile, err := os.Create(gadgetConfigFile)
-		defer func(newFile *os.File) {
-			err := newFile.Close()
-			if err != nil {
-
-			}
-		}(newFile)
-
-		if err != nil {
-			cmd.PrintfDanger("%v", err)
+		makeGadgetConfig(wd, mode, input)
 
-			return
-		}
+		return
+	}
 
-		_, err = newFile.WriteString(config.GetSampleConfigFileContent())
-		if err != nil {
-			cmd.PrintfDanger("%v", err)
-		}
+	if len(args) > 1 {
+		cmd.PrintfWarning("Usage: %v", make.info().usage())
 
 		return
 	}
@@ -164,14 +349,16 @@ func (make makeCommand) execute(input *bufio.Scanner, args []string) {
 	// check if template path exists.
 	_, err = os.Stat(newTemplatePath)
 	if err != nil {
-		cmd.FormatDanger("Failed to locate template directory: %v", path)
+		cmd.PrintfDanger("Failed to locate template directory: %v", path)
 
 		return
 	}
 
 	scaf, err := scaffold.Init(newTemplatePath)
 	if err != nil {
-		cmd.FormatDanger("Failed to initialize scaffold. Does %v/scaffold.toml exist?", newTemplatePath)
+		cmd.PrintfDanger("Failed to initialize scaffold. Does %v/scaffold.toml exist?", newTemplatePath)
+
+		return
 	}
 
 	// get all tokens. Those that don't have a ValueToken set, prompt for value
@@ -180,12 +367,16 @@ func (make makeCommand) execute(input *bufio.Scanner, args []string) {
 		if token.ValueToken == "" {
 			// prompt for token value
 			for token.Value == "" {
-				print(cmd.FormatInfo("Enter value for token %v: ", token.Name))
+				value, err := input.readLine(cmd.FormatInfo("Enter value for token %v: ", token.Name))
+				if err != nil {
+					cmd.PrintfWarning("Make cancelled")
 
-				input.Scan()
-				if input.Text() != "" {
-					scaf.RegisterTokenValue(token.Name, input.Text())
-					token.Value = input.Text()
+					return
+				}
+
+				if value != "" {
+					scaf.RegisterTokenValue(token.Name, value)
+					token.Value = value
 				}
 			}
 		}
@@ -197,6 +388,6 @@ func (make makeCommand) execute(input *bufio.Scanner, args []string) {
 
 	err = scaf.Make(wd)
 	if err != nil {
-		panic(err)
+		cmd.PrintfDanger("Failed to make %v: %v", path, err)
 	}
 }
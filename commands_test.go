@@ -1,22 +1,63 @@
 package main
 
 import (
-	"bufio"
 	"os"
 	"testing"
 )
 
 func TestMakeGadetConfigCommand(t *testing.T) {
-	conf := getConfigWithFlags()
+	// keep the user's ~/.clanko-gadget-cli/config.toml out of the test
+	t.Setenv("HOME", t.TempDir())
 
-	builder := newBuilder(conf)
-	watcher := newWatcher(conf)
+	// the command writes gadget.toml to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.Chdir(wd) })
 
-	gsh := newGadgetShell(&builder, &watcher)
+	conf, err := getConfigWithFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	input := bufio.NewScanner(os.Stdin)
+	builders := newBuilders(conf)
+	processes := newProcesses(conf)
+	watcher := newWatcher(conf)
+
+	gsh := newGadgetShell(builders, processes, newUnits(conf, builders, processes), &watcher, conf)
 
 	command := gsh.getCommand("make")
 
-	command.execute(input, []string{"gadget-config"})
+	command.execute(gsh.editor, []string{"gadget-config"})
+}
+
+func TestValidateArgs(t *testing.T) {
+	info := makeCommand{}.info()
+
+	if info.validateArgs([]string{}) == nil {
+		t.Errorf("Expected make without a template to be a usage error")
+	}
+
+	if info.validateArgs([]string{"gadget-config"}) != nil {
+		t.Errorf("Expected make with a template to be valid")
+	}
+
+	if info.validateArgs([]string{"gadget-config", "--auto"}) != nil {
+		t.Errorf("Expected make gadget-config --auto to be valid")
+	}
+
+	if info.validateArgs([]string{"a", "b", "c"}) == nil {
+		t.Errorf("Expected make with three arguments to be a usage error")
+	}
+
+	if (buildCommand{}).info().validateArgs([]string{"api", "worker", "gateway"}) != nil {
+		t.Errorf("Expected build to take any number of services")
+	}
 }
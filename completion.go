@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// complete returns where the word being completed starts in line, and the candidates for it: gadget commands for the
+// first word, template names for make, service and process names for commands acting on them, and file paths
+// otherwise.
+func (gsh *gadgetShell) complete(line string) (int, []string) {
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	previous := strings.Fields(line[:start])
+
+	var options []string
+	if len(previous) == 0 {
+		for name := range gsh.getCommands() {
+			options = append(options, name)
+		}
+	} else if len(previous) == 1 {
+		switch previous[0] {
+		case "make":
+			options = append(gsh.makeTemplateNames(), "gadget-config")
+		case "build", "run":
+			// a package to build
+			if previous[0] == "build" && strings.HasPrefix(word, ".") {
+				break
+			}
+
+			for _, builder := range gsh.builders {
+				options = append(options, builder.name())
+			}
+		case "profile":
+			options = gsh.config.ProfileNames()
+		case "pprof":
+			options = pprofKinds
+		case "variant":
+			options = config.VariantNames
+		case "coverage":
+			options = []string{"reset"}
+			for _, builder := range gsh.builders {
+				options = append(options, builder.name())
+			}
+		case "debug", "dev", "restart":
+			options = gsh.unitNames()
+		case "logs":
+			options = append(gsh.unitNames(), "grep", "since", "save")
+			for _, builder := range gsh.builders {
+				options = append(options, builder.debuggerSource())
+			}
+		}
+	}
+
+	if options == nil {
+		return start, completePath(word)
+	}
+
+	candidates := make([]string, 0)
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			candidates = append(candidates, option)
+		}
+	}
+
+	sort.Strings(candidates)
+
+	return start, candidates
+}
+
+func (gsh *gadgetShell) unitNames() []string {
+	names := make([]string, 0, len(gsh.units))
+	for _, unit := range gsh.units {
+		names = append(names, unit.name())
+	}
+
+	return names
+}
+
+func (gsh *gadgetShell) makeTemplateNames() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(home + "/" + gadgetCliConfigDir + "/make-templates")
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names
+}
+
+// completePath completes a file path. Directories end with a slash, so completion can continue into them.
+func completePath(word string) []string {
+	dir, base := filepath.Split(word)
+
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	candidates := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+
+		// hidden files only when asked for
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		if entry.IsDir() {
+			name += "/"
+		}
+
+		candidates = append(candidates, dir+name)
+	}
+
+	sort.Strings(candidates)
+
+	return candidates
+}
@@ -1,74 +1,380 @@
 package config
 
 import (
+	"fmt"
 	"github.com/clanko/gadget/cmd"
-	"github.com/pelletier/go-toml/v2"
 	"os"
+	"path/filepath"
+	"sort"
 )
 
 type Config struct {
-	Name          string   `toml:"app_name"`
-	Path          string   `toml:"app_path"`
-	Address       string   `toml:"app_address"`
-	BuildArgs     []string `toml:"build_args"`
-	ListenPort    int      `toml:"listen_port"`
-	ListenHost    string   `toml:"listen_host"`
-	ExcludeDirs   []string `toml:"exclude_dirs"`
-	ExcludeFiles  []string `toml:"exclude_files"`
-	ExcludeExts   []string `toml:"exclude_exts"`
-	ExcludePrefix []string `toml:"exclude_prefix"`
-	IncludeDirs   []string `toml:"include_dirs"`
-	IncludeFiles  []string `toml:"include_files"`
-}
-
-func GetConfig(configPath string) Config {
+	Name    string `toml:"app_name"`
+	Path    string `toml:"app_path"`
+	Address string `toml:"app_address"`
+	// flag the app reads its address from, e.g. "addr" to run it with -addr localhost:8090. Without one, the address
+	// is the app's first argument.
+	AddressFlag string   `toml:"address_flag"`
+	BuildArgs   []string `toml:"build_args"`
+	// one of VariantNames, adding its go build arguments before build_args
+	Variant string `toml:"variant"`
+	// main package to build, like ./cmd/api, relative to app_path. Services have their own.
+	BuildPackage string `toml:"build_package"`
+	// processes the app waits for before it starts, when there are no services. Services have their own.
+	DependsOn     []string           `toml:"depends_on"`
+	ListenPort    int                `toml:"listen_port"`
+	ListenHost    string             `toml:"listen_host"`
+	ExcludeDirs   []string           `toml:"exclude_dirs"`
+	ExcludeFiles  []string           `toml:"exclude_files"`
+	ExcludeExts   []string           `toml:"exclude_exts"`
+	ExcludePrefix []string           `toml:"exclude_prefix"`
+	IncludeDirs   []string           `toml:"include_dirs"`
+	IncludeFiles  []string           `toml:"include_files"`
+	Services      []Service          `toml:"services"`
+	Processes     []Process          `toml:"process"`
+	Output        Output             `toml:"output"`
+	LogFormat     LogFormat          `toml:"log_format"`
+	LogFiles      LogFiles           `toml:"log_files"`
+	Commands      map[string]Command `toml:"commands"`
+	Control       Control            `toml:"control"`
+	Pprof         Pprof              `toml:"pprof"`
+	// [profile.<name>] tables, overlaid on the config by applyProfile
+	Profiles map[string]map[string]any `toml:"profile"`
+
+	// the profile applied, empty for the base config
+	Profile string `toml:"-"`
+
+	// set per service by ServiceConfigs
+	ServiceName string   `toml:"-"`
+	Args        []string `toml:"-"`
+	Env         []string `toml:"-"`
+	HealthCheck string   `toml:"-"`
+}
+
+// Service is one of several apps built and run from the same project, e.g. an API and a worker.
+type Service struct {
+	Name         string   `toml:"name"`
+	BuildPackage string   `toml:"build_package"`
+	Args         []string `toml:"args"`
+	Env          []string `toml:"env"`
+	Address      string   `toml:"address"`
+	AddressFlag  string   `toml:"address_flag"`
+	DebugPort    int      `toml:"debug_port"`
+	// services that must be ready before this one starts
+	DependsOn []string `toml:"depends_on"`
+	// path requested on the service's address to tell when it's ready. Without one, the service is ready once its
+	// address accepts connections.
+	HealthCheck string `toml:"health_check"`
+	// address serving the service's pprof endpoints, when it isn't its address
+	PprofAddress string `toml:"pprof_address"`
+}
+
+// Output is how lines printed by the app, the debugger and other processes are shown.
+type Output struct {
+	Prefix      bool `toml:"prefix"`
+	Timestamps  bool `toml:"timestamps"`
+	StderrColor bool `toml:"stderr_color"`
+	// number of lines kept for the logs command
+	Scrollback int `toml:"scrollback"`
+}
+
+// LogFormat renders structured JSON and logfmt log lines as readable lines.
+type LogFormat struct {
+	Pretty   bool     `toml:"pretty"`
+	MinLevel string   `toml:"min_level"`
+	HideKeys []string `toml:"hide_keys"`
+}
+
+// LogFiles writes everything printed in a session to files, one per source.
+type LogFiles struct {
+	Enabled bool   `toml:"enabled"`
+	Dir     string `toml:"dir"`
+	// size in megabytes a file grows to before it's rotated
+	MaxSize int `toml:"max_size"`
+	// number of files kept per source, including the one being written
+	MaxFiles int `toml:"max_files"`
+}
+
+// Control is the local API other tools use to drive a session, over a Unix socket and optionally HTTP.
+type Control struct {
+	Enabled bool `toml:"enabled"`
+	// path of the Unix socket, defaults to one per project in ~/.clanko-gadget-cli/run
+	Socket string `toml:"socket"`
+	// localhost address to serve the API over HTTP too, e.g. "localhost:3812"
+	HTTP string `toml:"http"`
+}
+
+// Pprof is where the pprof command fetches profiles from, and where it keeps them.
+type Pprof struct {
+	// address serving the pprof endpoints, defaults to the app's address
+	Address string `toml:"address"`
+	// path the pprof handlers are served on
+	Path string `toml:"path"`
+	// captures are saved in timestamped directories in it
+	Dir string `toml:"dir"`
+	// number of functions in a capture's summary
+	Top int `toml:"top"`
+}
+
+// Command is a shell command defined in gadget.toml. Each step is a gadget command or a shell command line, and can
+// use the command's arguments and values of the session like {{.Address}}.
+type Command struct {
+	Description string   `toml:"description"`
+	Args        string   `toml:"args"`
+	MinArgs     int      `toml:"min_args"`
+	Steps       []string `toml:"steps"`
+}
+
+// Process is a non-Go command gadget runs next to the app, like a frontend dev server or a mock of an external API.
+type Process struct {
+	Name    string   `toml:"name"`
+	Command string   `toml:"command"`
+	Dir     string   `toml:"dir"`
+	Env     []string `toml:"env"`
+	// "no", "on-failure" or "always". Defaults to "no"
+	Restart string `toml:"restart"`
+	// color of the process' output prefix: red, green, yellow, blue, magenta or cyan
+	Color       string   `toml:"color"`
+	DependsOn   []string `toml:"depends_on"`
+	Address     string   `toml:"address"`
+	HealthCheck string   `toml:"health_check"`
+}
+
+// FileName is the config file gadget looks for.
+const FileName = "gadget.toml"
+
+// Flags are values set on the command line, they take precedence over the config file. Zero values aren't set.
+type Flags struct {
+	Path       string
+	Name       string
+	ListenPort int
+	// the [profile.<name>] to overlay on the config
+	Profile string
+}
+
+// Find looks for gadget.toml in dir and then each of its parents, like go finds go.mod.
+func Find(dir string) (string, bool) {
+	for {
+		configPath := filepath.Join(dir, FileName)
+		if stat, err := os.Stat(configPath); err == nil && !stat.IsDir() {
+			return configPath, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+
+		dir = parent
+	}
+}
+
+// GetConfig loads the config file at configPath and prints the problems found in it. It returns an error when the
+// config can't be used.
+func GetConfig(configPath string) (Config, error) {
+	return GetConfigWithFlags(configPath, Flags{})
+}
+
+// GetConfigWithFlags is GetConfig with the values of flags set on the command line.
+func GetConfigWithFlags(configPath string, flags Flags) (Config, error) {
+	config, problems := Load(configPath, flags)
+
+	PrintProblems(configPath, problems)
+
+	if problems.HasErrors() {
+		return config, fmt.Errorf("%v has errors", configPath)
+	}
+
+	return config, nil
+}
+
+// Load reads the config file at configPath and checks it, returning every problem found. Without the file, it's
+// the default config.
+//
+// Relative paths in the file are relative to the directory of the file, which is also the default app_path. Paths
+// set by flags are relative to the working directory.
+func Load(configPath string, flags Flags) (Config, Problems) {
+	config, _, problems := LoadWithOrigins(configPath, flags)
+
+	return config, problems
+}
+
+// LoadWithOrigins loads the config in layers, each overriding the one before: gadget's defaults, the user's
+// ~/.clanko-gadget-cli/config.toml, the project's gadget.toml, gadget.local.toml next to it, GADGET_* environment
+// variables, and the flags. It also returns where each value set came from.
+func LoadWithOrigins(configPath string, flags Flags) (Config, Origins, Problems) {
 	config := getDefaultConfig()
+	origins := make(Origins)
 
-	content, err := os.ReadFile(configPath)
-	if err != nil {
+	_, err := os.Stat(configPath)
+	hasFile := err == nil
+
+	layers := []string{GlobalFile()}
+	if hasFile {
+		config.Path = filepath.Dir(configPath)
+
+		layers = append(layers, configPath, LocalFile(configPath))
+	} else {
 		cmd.PrintfWarning("No gadget.toml found. Using default config")
+	}
 
-		return config
+	problems := make(Problems, 0)
+	for _, layer := range layers {
+		file := layer
+		if layer == configPath {
+			file = ""
+		}
+
+		problems = append(problems, config.loadFile(layer, file, origins)...)
 	}
 
-	err = toml.Unmarshal(content, &config)
-	if err != nil {
-		panic(cmd.FormatDanger("Failed to parse config file\n%v", err))
+	if problems.HasErrors() {
+		return config, origins, problems
 	}
 
-	wd, err := os.Getwd()
-	if err != nil {
-		panic(cmd.FormatDanger(err.Error()))
+	if flags.Profile != "" {
+		problems = append(problems, config.applyProfile(flags.Profile, filepath.Dir(configPath), origins)...)
+		if problems.HasErrors() {
+			return config, origins, problems
+		}
 	}
 
-	// loop through and modify any relative paths to absolute
-	for i, file := range config.ExcludeFiles {
-		if string(file[0]) != "/" {
-			config.ExcludeFiles[i] = wd + "/" + file
+	problems = append(problems, config.loadEnv(os.Environ(), origins)...)
+
+	config.applyFlags(flags, origins)
+
+	if !hasFile {
+		return config, origins, problems
+	}
+
+	config.LogFiles.Dir = resolvePath(config.Path, config.LogFiles.Dir)
+	config.Pprof.Dir = resolvePath(config.Path, config.Pprof.Dir)
+
+	problems = append(problems, config.validate(origins)...)
+
+	config.ExcludeFiles = append(config.ExcludeFiles, config.Path+"/"+config.Name)
+
+	// writing logs must not trigger a rebuild
+	if config.LogFiles.Enabled {
+		config.ExcludeDirs = append(config.ExcludeDirs, config.LogFiles.Dir)
+	}
+
+	for _, service := range config.Services {
+		config.ExcludeFiles = append(config.ExcludeFiles, config.Path+"/"+config.ServiceBinaryName(service))
+	}
+
+	// file by file in the order they're loaded, line by line, problems of a whole file last
+	order := map[string]int{GlobalFile(): 0, "": 1, LocalFile(configPath): 2}
+	rank := func(problem Problem) int {
+		if rank, ok := order[problem.File]; ok {
+			return rank
 		}
+
+		return len(order)
 	}
 
-	for i, file := range config.ExcludeDirs {
-		if string(file[0]) != "/" {
-			config.ExcludeDirs[i] = wd + "/" + file
+	sort.SliceStable(problems, func(i, j int) bool {
+		if rank(problems[i]) != rank(problems[j]) {
+			return rank(problems[i]) < rank(problems[j])
 		}
+
+		return problems[j].Line == 0 && problems[i].Line != 0 || problems[i].Line != 0 && problems[i].Line < problems[j].Line
+	})
+
+	return config, origins, problems
+}
+
+func (config *Config) applyFlags(flags Flags, origins Origins) {
+	if flags.Path != "" {
+		path, err := filepath.Abs(flags.Path)
+		if err == nil {
+			config.Path = path
+			origins["app_path"] = Origin{Flag: "path"}
+		}
+	}
+
+	if flags.Name != "" {
+		config.Name = flags.Name
+		origins["app_name"] = Origin{Flag: "binary"}
+	}
+
+	if flags.ListenPort != 0 {
+		config.ListenPort = flags.ListenPort
+		origins["listen_port"] = Origin{Flag: "listen"}
+	}
+}
+
+// resolvePath makes a relative path absolute from baseDir. Empty paths are left for validation to report.
+func resolvePath(baseDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(baseDir, path)
+}
+
+// ServiceConfigs returns the config used to build and run each service, in start order. Without any [[services]] the
+// config itself describes the only app.
+func (config Config) ServiceConfigs() []Config {
+	if len(config.Services) == 0 {
+		return []Config{config}
 	}
 
-	for i, file := range config.IncludeFiles {
-		if string(file[0]) != "/" {
-			config.IncludeFiles[i] = wd + "/" + file
+	services := make(map[string]Service)
+	for _, service := range config.Services {
+		services[service.Name] = service
+	}
+
+	order, err := config.StartOrder()
+	if err != nil {
+		// GetConfig already rejected invalid dependencies, start in declared order
+		order = make([]string, 0, len(config.Services))
+		for _, service := range config.Services {
+			order = append(order, service.Name)
 		}
 	}
 
-	for i, file := range config.IncludeDirs {
-		if string(file[0]) != "/" {
-			config.IncludeDirs[i] = wd + "/" + file
+	configs := make([]Config, 0, len(config.Services))
+	for _, name := range order {
+		service, ok := services[name]
+		if !ok {
+			// a process
+			continue
 		}
+
+		serviceConfig := config
+		serviceConfig.Services = nil
+		serviceConfig.Name = config.ServiceBinaryName(service)
+		serviceConfig.ServiceName = service.Name
+		serviceConfig.BuildPackage = service.BuildPackage
+		serviceConfig.Args = service.Args
+		serviceConfig.Env = service.Env
+		serviceConfig.Address = service.Address
+		serviceConfig.DependsOn = service.DependsOn
+		serviceConfig.HealthCheck = service.HealthCheck
+
+		if service.AddressFlag != "" {
+			serviceConfig.AddressFlag = service.AddressFlag
+		}
+
+		if service.DebugPort != 0 {
+			serviceConfig.ListenPort = service.DebugPort
+		}
+
+		if service.PprofAddress != "" {
+			serviceConfig.Pprof.Address = service.PprofAddress
+		}
+
+		configs = append(configs, serviceConfig)
 	}
 
-	config.ExcludeFiles = append(config.ExcludeFiles, config.Path+"/"+config.Name)
+	return configs
+}
 
-	return config
+// ServiceBinaryName is the binary built for a service. It's prefixed with app_name so it can't collide with a
+// directory named after the service.
+func (config Config) ServiceBinaryName(service Service) string {
+	return config.Name + "_" + service.Name
 }
 
 func getDefaultConfig() Config {
@@ -80,17 +386,42 @@ func getDefaultConfig() Config {
 	return Config{
 		Name:       "gadget_binary",
 		Path:       wd,
-		BuildArgs:  []string{`-gcflags=all=-N -l`},
+		Variant:    "debug",
 		ListenPort: 3811,
 		ListenHost: "127.0.0.1",
+		Output: Output{
+			Prefix:      true,
+			StderrColor: true,
+			Scrollback:  10000,
+		},
+		LogFiles: LogFiles{
+			Dir:      ".gadget/logs",
+			MaxSize:  10,
+			MaxFiles: 5,
+		},
+		Control: Control{
+			Enabled: true,
+		},
+		Pprof: Pprof{
+			Path: "/debug/pprof",
+			Dir:  ".gadget/profiles",
+			Top:  10,
+		},
 	}
 }
 
 func GetSampleConfigFileContent() string {
 	return `
 # Skip the command line flags and use a gadget.toml configuration file.
-# Either pass the config location as a command or just execute gadget from the same directory.
+# Gadget uses the gadget.toml in the current directory or the closest parent with one, or the file passed with -config.
 # Flags specified in the command will override values set in the configuration file.
+# Relative paths in this file are relative to the directory of this file.
+# Settings are layered, each overriding the one before: gadget's defaults, ~/.clanko-gadget-cli/config.toml,
+#   this file, gadget.local.toml next to it for your own settings (keep it out of git), GADGET_* environment
+#   variables like GADGET_LISTEN_PORT or GADGET_OUTPUT_TIMESTAMPS, and flags. gadget config show --origin shows
+#   where each value came from.
+# Strings can use environment variables: ${VAR}, or ${VAR:-default} when VAR is unset or empty. $${ is a literal ${.
+#   e.g. app_address = "localhost:${PORT:-8090}"
 
 # The name of the binary.
 # app_name = "gadget_binary"
@@ -98,11 +429,29 @@ func GetSampleConfigFileContent() string {
 # The address to run the app on. If none is specified, Gadget will try to find an available port on localhost to use.
 # app_address = "localhost:8090"
 
-# The path to the project to build, if no value is set, Gadget will use the current directory.
+# The app gets its address as its first argument. Set the flag it reads the address from instead, to run it with
+#   e.g. -addr localhost:8090.
+# address_flag = "addr"
+
+# The path to the project to build, if no value is set, Gadget will use the directory of this file.
 # app_path = "/path/to/project"
 
-# Arguments to pass to go when building the binary.
-# build_args = ["-gcflags=all=-N -l"]
+# The main package to build, relative to app_path, when it isn't app_path itself. Without one, and with several main
+#   packages in the project, gadget asks which one to build when it starts. A pattern like ./cmd/... asks among the
+#   packages it matches.
+# build_package = "./cmd/api"
+
+# Processes that must be ready before the app starts, when there are no services. Services set their own.
+# depends_on = ["db"]
+
+# How to build the app: debug, without optimizations for the debugger, race with the race detector, cover to collect
+#   coverage while it runs (see the coverage shell command), or release, optimized and without a debugger. race and
+#   cover are debugged too, and also build without optimizations. The variant command switches it for the session.
+# variant = "debug"
+
+# Arguments to pass to go when building the binary, after the variant's. A flag set here, like -gcflags, replaces the
+#   variant's.
+# build_args = ["-tags=dev"]
 
 # The port to connect debugger. If not set, Gadget will find an available port.
 # listen_port = 3811
@@ -128,5 +477,107 @@ exclude_files = ["README.md"]
 # Files that should prompt rebuild.
 # include_files = []
 
+# Run several apps from the same project, each with its own binary, process and debugger. Changes to a package only
+#   rebuild the services that import it. Without any services, the settings above describe the only app.
+# [[services]]
+# name = "api"
+# build_package = "./cmd/api"
+# args = ["-verbose"]
+# env = ["DB_HOST=localhost"]
+# address = "localhost:8090"
+# address_flag = "addr"
+# debug_port = 3812
+# Services that must be ready before this one starts. Services stop in the reverse order.
+# depends_on = ["db-mock"]
+# Path requested on the address to tell when the service is ready. Without one, the service is ready once its
+#   address accepts connections.
+# health_check = "/healthz"
+# Where the service serves net/http/pprof, when it isn't its address.
+# pprof_address = "localhost:6061"
+
+# How lines printed by the app, the debugger and processes are shown.
+# [output]
+# Prefix lines with their source, e.g. [api], [dlv:api] or [frontend]. Each source keeps the same color.
+# prefix = true
+# Prefix lines with the time they were printed, to the millisecond.
+# timestamps = false
+# Paint stderr lines red. Turn off for apps that log everything to stderr.
+# stderr_color = true
+# Number of lines kept for the logs shell command.
+# scrollback = 10000
+
+# Render structured log lines, like those of log/slog's JSON and text handlers, as readable lines colored by level.
+# [log_format]
+# pretty = true
+# Structured lines below this level aren't shown: debug, info, warn or error.
+# min_level = "info"
+# Attributes that aren't shown.
+# hide_keys = ["request_id"]
+
+# Write everything printed by the app, Delve, processes and gadget to files, one per source per session, without
+#   colors. A marker is written at each build so logs can be matched with code changes.
+# [log_files]
+# enabled = true
+# Relative to app_path. The directory is excluded from watching.
+# dir = ".gadget/logs"
+# Size in megabytes a file grows to before it's rotated.
+# max_size = 10
+# Number of files kept per source, including the one being written.
+# max_files = 5
+
+# A local API to drive the session from scripts and editors, and follow its events. It's served on a Unix socket
+#   readable only by you, by default one per project in ~/.clanko-gadget-cli/run. Set http to a localhost address to
+#   serve it over HTTP too.
+# [control]
+# enabled = true
+# socket = "/tmp/gadget.sock"
+# http = "localhost:3812"
+
+# Where the pprof shell command fetches profiles, e.g. pprof cpu 30s, pprof heap or pprof goroutine. The app
+#   serves them by importing net/http/pprof, by default on its address. Each capture is saved in a timestamped
+#   directory of dir, relative to app_path, and summarized by its top functions.
+# [pprof]
+# address = "localhost:6060"
+# path = "/debug/pprof"
+# dir = ".gadget/profiles"
+# top = 10
+
+# Shell commands made of steps, each a gadget command like build or run, or a shell command line. Steps run in order
+#   and stop at the first shell command that fails. Steps are templates with these values: {{.Address}},
+#   {{.DebugPort}}, {{.Path}} and {{.Name}} of the app or first service, {{.Services}} by name, e.g.
+#   {{(index .Services "api").Address}}, {{.Args}}, and {{arg 1}} for the first argument. {{quote (arg 1)}} keeps
+#   a value with spaces a single argument.
+# [commands.seed]
+# description = "Rebuilds, migrates and seeds the database"
+# args = "<count>"
+# min_args = 1
+# steps = [
+#     "build",
+#     "go run ./cmd/migrate",
+#     "run",
+#     "curl -X POST http://{{.Address}}/seed?count={{arg 1}}",
+# ]
+
+# Profiles are variants of the config, picked with -profile or the profile command of the shell, e.g. one running
+#   with the race detector, or against staging. A profile sets only what differs: tables merge key by key, lists
+#   replace the base list, unless named in append, then the profile's entries come after the base ones.
+# [profile.race]
+# variant = "race"
+# append = ["exclude_dirs"]
+# exclude_dirs = ["testdata"]
+
+# Commands that aren't Go apps, run next to them, like a frontend dev server or a mock of an external API. Processes
+#   run in their own process group and their output is prefixed with their name. Restart policies are "no",
+#   "on-failure" and "always". A process can depend on services and processes, and they can depend on it. Without
+#   services, the app can be depended on by its app_name.
+# [[process]]
+# name = "frontend"
+# command = "npm run dev"
+# dir = "web"
+# env = ["PORT=5173"]
+# restart = "on-failure"
+# color = "magenta"
+# address = "localhost:5173"
+
 `
 }
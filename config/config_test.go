@@ -63,8 +63,14 @@ func compareStringSlices(expectedSlice, givenSlice []string) (notFound string, u
 }
 
 func TestGetConfig(t *testing.T) {
+	// keep the user's ~/.clanko-gadget-cli/config.toml out of the test
+	t.Setenv("HOME", t.TempDir())
+
 	// GetConfig with a non-existent config file returns the default Config
-	conf := GetConfig("")
+	conf, err := GetConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	defaultConf := getDefaultConfig()
 
@@ -77,7 +83,10 @@ func TestGetConfig(t *testing.T) {
 		panic(err)
 	}
 
-	loadedConf := GetConfig(wd + "/../_testdata/config.toml")
+	loadedConf, err := GetConfig(wd + "/../_testdata/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	rootPath, err := filepath.Abs("../")
 	if err != nil {
@@ -253,3 +262,236 @@ func replaceLoadedConfigPath(search string, replacement string, config *Config)
 		}
 	}
 }
+
+func TestServiceConfigs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	conf, err := GetConfig("../_testdata/services.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := conf.ServiceConfigs()
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 service configs, got %v", len(configs))
+	}
+
+	api := configs[0]
+	if api.ServiceName != "api" || api.Name != "appNameTest_api" || api.BuildPackage != "./cmd/api" {
+		t.Errorf("Unexpected api service config: %+v", api)
+	}
+
+	if api.Address != "localhost:8090" || api.ListenPort != 3812 {
+		t.Errorf("Expected api address and debug port from the service, got %v and %v", api.Address, api.ListenPort)
+	}
+
+	if reflect.DeepEqual(api.Args, []string{"-verbose"}) == false || reflect.DeepEqual(api.Env, []string{"DB_HOST=localhost"}) == false {
+		t.Errorf("Expected api args and env from the service, got %v and %v", api.Args, api.Env)
+	}
+
+	worker := configs[1]
+	if worker.ListenPort != 3811 {
+		t.Errorf("Expected worker to fall back to listen_port 3811, got %v", worker.ListenPort)
+	}
+
+	order, err := conf.StartOrder()
+	if err != nil || reflect.DeepEqual(order, []string{"mock", "api", "worker"}) == false {
+		t.Errorf("Expected processes to start before services, got %v %v", order, err)
+	}
+
+	excluded := false
+	for _, file := range conf.ExcludeFiles {
+		if file == conf.Path+"/appNameTest_worker" {
+			excluded = true
+		}
+	}
+
+	if !excluded {
+		t.Errorf("Expected the worker binary to be excluded from watching")
+	}
+
+	// without services the config describes the only app
+	single, err := GetConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(single.ServiceConfigs()) != 1 || single.ServiceConfigs()[0].Name != single.Name {
+		t.Errorf("Expected a single config without services")
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	conf := Config{Services: []Service{
+		{Name: "worker", DependsOn: []string{"api"}},
+		{Name: "gateway", DependsOn: []string{"api", "worker"}},
+		{Name: "api"},
+	}}
+
+	order, err := conf.StartOrder()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if reflect.DeepEqual(order, []string{"api", "worker", "gateway"}) == false {
+		t.Errorf("Unexpected start order: %v", order)
+	}
+
+	conf.Services[2].DependsOn = []string{"gateway"}
+
+	_, err = conf.StartOrder()
+	if err == nil || strings.Contains(err.Error(), "cycle") == false {
+		t.Errorf("Expected a dependency cycle error, got %v", err)
+	}
+
+	conf.Services[2].DependsOn = []string{"db"}
+
+	_, err = conf.StartOrder()
+	if err == nil || strings.Contains(err.Error(), "db") == false {
+		t.Errorf("Expected an undefined dependency error, got %v", err)
+	}
+
+	single := Config{Name: "app", DependsOn: []string{"db"}, Processes: []Process{{Name: "db"}, {Name: "frontend", DependsOn: []string{"app"}}}}
+
+	order, err = single.StartOrder()
+	if err != nil || reflect.DeepEqual(order, []string{"db", "app", "frontend"}) == false {
+		t.Errorf("Expected the app after db and before frontend, got %v, %v", order, err)
+	}
+
+	conf.Services[2].DependsOn = nil
+	conf.DependsOn = []string{"db"}
+
+	if problems := conf.validateUnits(Origins{}); !problems.HasErrors() {
+		t.Errorf("Expected an error for depends_on next to services")
+	}
+}
+
+func TestFindAndResolvePaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	root := t.TempDir()
+	nested := filepath.Join(root, "app", "internal")
+
+	err := os.MkdirAll(nested, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "app_path = \"app\"\nexclude_dirs = [\"app/internal\"]\n"
+
+	err = os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath, found := Find(nested)
+	if !found || configPath != filepath.Join(root, FileName) {
+		t.Fatalf("Expected to find the config in a parent directory, got %v", configPath)
+	}
+
+	conf, problems := Load(configPath, Flags{})
+	if len(problems) > 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	if conf.Path != filepath.Join(root, "app") {
+		t.Errorf("Expected app_path relative to the config file, got %v", conf.Path)
+	}
+
+	if conf.ExcludeDirs[0] != nested {
+		t.Errorf("Expected exclude_dirs relative to the config file, got %v", conf.ExcludeDirs[0])
+	}
+}
+
+func TestLoadWithProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	root := t.TempDir()
+
+	content := `app_name = "app"
+build_args = ["-tags", "dev"]
+exclude_dirs = ["vendor"]
+
+[output]
+prefix = false
+
+[profile.ci]
+append = ["exclude_dirs"]
+build_args = ["-race"]
+exclude_dirs = ["testdata"]
+
+[profile.ci.output]
+timestamps = true
+
+[profile.broken]
+exclude_dirs = [""]
+`
+
+	err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, problems := Load(filepath.Join(root, FileName), Flags{})
+	if problems.HasErrors() {
+		t.Fatalf("Expected no errors, got %v", problems)
+	}
+
+	conf, problems := Load(filepath.Join(root, FileName), Flags{Profile: "ci"})
+	if problems.HasErrors() {
+		t.Fatalf("Expected no errors, got %v", problems)
+	}
+
+	if conf.Profile != "ci" || conf.Name != "app" {
+		t.Errorf("Expected the base config with profile ci, got %v %v", conf.Name, conf.Profile)
+	}
+
+	if strings.Join(conf.BuildArgs, " ") != "-race" {
+		t.Errorf("Expected the profile's build_args to replace the base ones, got %v", conf.BuildArgs)
+	}
+
+	if conf.ExcludeDirs[0] != filepath.Join(root, "vendor") || conf.ExcludeDirs[1] != filepath.Join(root, "testdata") {
+		t.Errorf("Expected the profile's exclude_dirs after the base ones, got %v", conf.ExcludeDirs)
+	}
+
+	if conf.Output.Prefix || !conf.Output.Timestamps {
+		t.Errorf("Expected output to merge key by key, got %+v", conf.Output)
+	}
+
+	if strings.Join(base.BuildArgs, " ") != "-tags dev" {
+		t.Errorf("Expected the base config unchanged, got %v", base.BuildArgs)
+	}
+
+	_, problems = Load(filepath.Join(root, FileName), Flags{Profile: "broken"})
+	if len(problems) != 1 || problems[0].Line != 17 {
+		t.Errorf("Expected a problem on line 17, got %v", problems)
+	}
+
+	_, problems = Load(filepath.Join(root, FileName), Flags{Profile: "missing"})
+	if !problems.HasErrors() || !strings.Contains(problems[0].Message, "broken, ci") {
+		t.Errorf("Expected an unknown profile error listing the profiles, got %v", problems)
+	}
+}
+
+func TestBuildFlags(t *testing.T) {
+	conf := Config{Variant: "race", BuildArgs: []string{"-tags=dev"}}
+	if strings.Join(conf.BuildFlags(), " ") != "-race -gcflags=all=-N -l -tags=dev" {
+		t.Errorf("Expected race without optimizations, then build_args, got %v", conf.BuildFlags())
+	}
+
+	// configs written when -gcflags was the default build_args
+	conf = Config{Variant: "debug", BuildArgs: []string{"-gcflags=all=-N -l"}}
+	if len(conf.BuildFlags()) != 1 || len(conf.validateVariant(Origins{})) != 0 {
+		t.Errorf("Expected -gcflags once without a warning, got %v", conf.BuildFlags())
+	}
+
+	conf = Config{Variant: "cover", BuildArgs: []string{"-gcflags", "all=-l"}}
+	if strings.Join(conf.BuildFlags(), " ") != "-cover -gcflags all=-l" {
+		t.Errorf("Expected build_args to replace the variant's -gcflags, got %v", conf.BuildFlags())
+	}
+
+	problems := conf.validateVariant(Origins{})
+	if len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("Expected a warning about the replaced -gcflags, got %v", problems)
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StartOrder returns the service and process names ordered so that each comes after everything it depends on.
+// Processes are declared before services, otherwise the declared order is kept. Without services, the app is named
+// after app_name so processes can depend on it, and it depends on those of depends_on.
+func (config Config) StartOrder() ([]string, error) {
+	names := make([]string, 0, len(config.Services)+len(config.Processes)+1)
+	dependencies := make(map[string][]string)
+	for _, process := range config.Processes {
+		names = append(names, process.Name)
+		dependencies[process.Name] = process.DependsOn
+	}
+
+	for _, service := range config.Services {
+		names = append(names, service.Name)
+		dependencies[service.Name] = service.DependsOn
+	}
+
+	if len(config.Services) == 0 {
+		names = append(names, config.Name)
+		dependencies[config.Name] = config.DependsOn
+	}
+
+	return startOrder(names, dependencies)
+}
+
+func startOrder(names []string, dependencies map[string][]string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	order := make([]string, 0, len(names))
+	path := make([]string, 0)
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			// the path from the first visit of name back to name is the cycle
+			start := 0
+			for i := range path {
+				if path[i] == name {
+					start = i
+				}
+			}
+
+			cycle := append(append([]string{}, path[start:]...), name)
+
+			return fmt.Errorf("dependency cycle: %v", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dependency := range dependencies[name] {
+			if _, ok := dependencies[dependency]; !ok {
+				return fmt.Errorf("%v depends on %v, which isn't defined", name, dependency)
+			}
+
+			err := visit(dependency)
+			if err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		err := visit(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
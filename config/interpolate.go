@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// interpolate replaces ${VAR} with the value of the environment variable VAR, and ${VAR:-default} with default
+// when VAR is unset or empty. $${ is a literal ${. It returns the variables used that aren't set and have no
+// default.
+func interpolate(value string) (string, []string) {
+	var result strings.Builder
+	missing := make([]string, 0)
+
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			result.WriteString(value)
+
+			return result.String(), missing
+		}
+
+		if start > 0 && value[start-1] == '$' {
+			result.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+
+			continue
+		}
+
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			result.WriteString(value)
+
+			return result.String(), missing
+		}
+
+		result.WriteString(value[:start])
+
+		name, fallback, hasFallback := strings.Cut(value[start+2:start+end], ":-")
+		variable := os.Getenv(name)
+		if variable == "" && hasFallback {
+			variable = fallback
+		} else if _, set := os.LookupEnv(name); !set {
+			missing = append(missing, name)
+		}
+
+		result.WriteString(variable)
+		value = value[start+end+1:]
+	}
+}
+
+// interpolateTree interpolates the strings a config file set in the config it was decoded into. tree is the file
+// decoded as it is, to find them. lines are the lines keys are set on, for problems with variables that aren't set.
+func interpolateTree(tree any, value reflect.Value, path string, lines map[string]int, file string) Problems {
+	if text, ok := tree.(string); ok {
+		interpolated, problems := interpolateString(text, path, lines, file, make(Problems, 0))
+		if value.Kind() == reflect.String {
+			value.SetString(interpolated)
+		} else if value.Kind() == reflect.Interface {
+			value.Set(reflect.ValueOf(interpolated))
+		}
+
+		return problems
+	}
+
+	// values of profiles are kept as they're decoded
+	if value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+
+	problems := make(Problems, 0)
+
+	switch tree := tree.(type) {
+	case map[string]any:
+		for key, nested := range tree {
+			switch value.Kind() {
+			case reflect.Struct:
+				field, ok := fieldByKey(value, key)
+				if ok {
+					problems = append(problems, interpolateTree(nested, field, joinKey(path, key), lines, file)...)
+				}
+
+			case reflect.Map:
+				// map values can't be set in place
+				mapKey := reflect.ValueOf(key).Convert(value.Type().Key())
+				current := value.MapIndex(mapKey)
+				if !current.IsValid() {
+					continue
+				}
+
+				element := reflect.New(value.Type().Elem()).Elem()
+				element.Set(current)
+
+				problems = append(problems, interpolateTree(nested, element, joinKey(path, key), lines, file)...)
+				value.SetMapIndex(mapKey, element)
+			}
+		}
+
+	case []any:
+		if value.Kind() != reflect.Slice {
+			break
+		}
+
+		for i, nested := range tree[:min(len(tree), value.Len())] {
+			elementPath := path
+			if _, ok := nested.(string); !ok {
+				elementPath = joinKey(path, strconv.Itoa(i))
+			}
+
+			problems = append(problems, interpolateTree(nested, value.Index(i), elementPath, lines, file)...)
+		}
+	}
+
+	return problems
+}
+
+func interpolateString(text string, path string, lines map[string]int, file string, problems Problems) (string, Problems) {
+	interpolated, missing := interpolate(text)
+	for _, name := range missing {
+		problems = append(problems, Problem{
+			File:    file,
+			Line:    lineOf(lines, path),
+			Message: fmt.Sprintf("%v uses ${%v}, which isn't set", displayKey(path), name),
+			Warning: true,
+		})
+	}
+
+	return interpolated, problems
+}
+
+// lineOf is the line a key is set on, or the line of the closest table holding it.
+func lineOf(lines map[string]int, key string) int {
+	for {
+		if line, ok := lines[key]; ok {
+			return line
+		}
+
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return 0
+		}
+
+		key = key[:i]
+	}
+}
@@ -0,0 +1,392 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LocalFileName is the config file next to gadget.toml with a developer's own settings, meant to be gitignored.
+const LocalFileName = "gadget.local.toml"
+
+// envPrefix starts the environment variables setting config keys, e.g. GADGET_LISTEN_PORT or GADGET_OUTPUT_TIMESTAMPS.
+const envPrefix = "GADGET_"
+
+// keys holding paths, relative paths are resolved from the file setting them
+var pathKeys = []string{"app_path", "exclude_dirs", "exclude_files", "include_dirs", "include_files"}
+
+// GlobalFile is the user's config, applied to every project before its gadget.toml.
+func GlobalFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".clanko-gadget-cli", "config.toml")
+}
+
+// LocalFile is the gadget.local.toml next to a gadget.toml.
+func LocalFile(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), LocalFileName)
+}
+
+// Origin is where a config value came from. The zero Origin is gadget's default.
+type Origin struct {
+	// config file setting the value, empty for the project's gadget.toml
+	File string
+	Line int
+	// environment variable setting the value
+	Env string
+	// command line flag setting the value
+	Flag string
+}
+
+// Origins are the origins of the values set, keyed like "services.0.address".
+type Origins map[string]Origin
+
+// Describe shows where a value came from, e.g. gadget.toml:12, $GADGET_LISTEN_PORT or -listen.
+func (origin Origin) Describe(configPath string) string {
+	switch {
+	case origin.Env != "":
+		return "$" + origin.Env
+	case origin.Flag != "":
+		return "-" + origin.Flag
+	case origin.Line > 0:
+		file := origin.File
+		if file == "" {
+			file = configPath
+		}
+
+		return displayPath(file) + ":" + strconv.Itoa(origin.Line)
+	}
+
+	return "default"
+}
+
+// Lookup is the origin of a key, or of the list entry holding it. Keys of tables like [output] left out of a file
+// keep their defaults, so their origin isn't the table's.
+func (origins Origins) Lookup(key string) Origin {
+	for {
+		if origin, ok := origins[key]; ok {
+			return origin
+		}
+
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return Origin{}
+		}
+
+		key = key[:i]
+
+		if field, ok := fieldByKey(reflect.ValueOf(Config{}), key); ok && field.Kind() == reflect.Struct {
+			return Origin{}
+		}
+	}
+}
+
+func (origins Origins) problem(key string, message string) Problem {
+	// a problem with a key left out points at its table
+	var origin Origin
+	for path := key; origin == (Origin{}); path = path[:strings.LastIndex(path, ".")] {
+		origin = origins[path]
+
+		if !strings.Contains(path, ".") {
+			break
+		}
+	}
+
+	problem := Problem{File: origin.File, Line: origin.Line, Message: message}
+	if origin.Env != "" {
+		problem.File = "$" + origin.Env
+	} else if origin.Flag != "" {
+		problem.File = "-" + origin.Flag
+	}
+
+	return problem
+}
+
+func (origins Origins) warning(key string, message string) Problem {
+	problem := origins.problem(key, message)
+	problem.Warning = true
+
+	return problem
+}
+
+// set records the keys a layer set. Lists replace what earlier layers set, so origins of their entries go.
+func (origins Origins) set(lines map[string]int, origin Origin) {
+	for key := range lines {
+		if !strings.Contains(key, ".") {
+			for existing := range origins {
+				if strings.HasPrefix(existing, key+".") {
+					delete(origins, existing)
+				}
+			}
+		}
+	}
+
+	for key, line := range lines {
+		origin.Line = line
+		origins[key] = origin
+	}
+}
+
+// loadFile decodes a config file on top of the config. Missing files are skipped, they're all optional but the
+// project's. file is the path shown in problems, empty for the project's gadget.toml.
+func (config *Config) loadFile(path string, file string, origins Origins) Problems {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return Problems{{File: file, Message: err.Error()}}
+	}
+
+	problems, lines := checkKeys(content)
+	for i := range problems {
+		problems[i].File = file
+	}
+
+	if problems.HasErrors() {
+		return problems
+	}
+
+	err = toml.Unmarshal(content, config)
+	if err != nil {
+		problem := decodeProblem(err)
+		problem.File = file
+
+		return append(problems, problem)
+	}
+
+	// values are interpolated before paths are resolved, so ${VAR} works in any string, paths included
+	var tree map[string]any
+	_ = toml.Unmarshal(content, &tree)
+	problems = append(problems, interpolateTree(tree, reflect.ValueOf(config).Elem(), "", lines, file)...)
+
+	config.resolvePaths(filepath.Dir(path), lines)
+	origins.set(lines, Origin{File: file})
+
+	return problems
+}
+
+// resolvePaths makes the paths of the keys set absolute from baseDir.
+func (config *Config) resolvePaths(baseDir string, set map[string]int) {
+	for _, key := range pathKeys {
+		if _, ok := set[key]; !ok {
+			continue
+		}
+
+		field, _ := fieldByKey(reflect.ValueOf(config).Elem(), key)
+		if field.Kind() == reflect.String {
+			field.SetString(resolvePath(baseDir, field.String()))
+
+			continue
+		}
+
+		paths := make([]string, field.Len())
+		for i := range paths {
+			paths[i] = resolvePath(baseDir, field.Index(i).String())
+		}
+
+		field.Set(reflect.ValueOf(paths))
+	}
+}
+
+// loadEnv sets keys from GADGET_* environment variables, e.g. GADGET_OUTPUT_TIMESTAMPS=true. Lists are comma
+// separated. Variables that aren't config keys are left alone, gadget sets some for plugins.
+func (config *Config) loadEnv(environ []string, origins Origins) Problems {
+	problems := make(Problems, 0)
+	keys := envKeys(reflect.TypeOf(Config{}), "")
+
+	names := make([]string, 0)
+	values := make(map[string]string)
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if _, ok := keys[name]; ok {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+
+	sort.Strings(names)
+
+	wd, _ := os.Getwd()
+
+	for _, name := range names {
+		key := keys[name]
+		field, _ := fieldByKey(reflect.ValueOf(config).Elem(), key)
+
+		err := setFromString(field, values[name])
+		if err != nil {
+			problems = append(problems, Problem{File: "$" + name, Message: fmt.Sprintf("%v %v", key, err)})
+
+			continue
+		}
+
+		config.resolvePaths(wd, map[string]int{key: 0})
+		origins.set(map[string]int{key: 0}, Origin{Env: name})
+	}
+
+	return problems
+}
+
+// envKeys are the keys that can be set from the environment, by variable name. Lists of tables and maps can't.
+func envKeys(structType reflect.Type, prefix string) map[string]string {
+	keys := make(map[string]string)
+	for name, fieldType := range tomlFields(structType) {
+		key := joinKey(prefix, name)
+
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			for variable, nested := range envKeys(fieldType, key) {
+				keys[variable] = nested
+			}
+		case fieldType.Kind() == reflect.Map:
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() != reflect.String:
+		default:
+			keys[envPrefix+strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = key
+		}
+	}
+
+	return keys
+}
+
+func setFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Int, reflect.Int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("should be a whole number, not %q", value)
+		}
+
+		field.SetInt(number)
+
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("should be a number, not %q", value)
+		}
+
+		field.SetFloat(number)
+
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("should be true or false, not %q", value)
+		}
+
+		field.SetBool(boolean)
+
+	case reflect.Slice:
+		values := make([]string, 0)
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				values = append(values, entry)
+			}
+		}
+
+		field.Set(reflect.ValueOf(values))
+	}
+
+	return nil
+}
+
+// fieldByKey finds the field of a struct with a dotted TOML key, like output.prefix.
+func fieldByKey(value reflect.Value, key string) (reflect.Value, bool) {
+	for _, part := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			if strings.Split(value.Type().Field(i).Tag.Get("toml"), ",")[0] == part && part != "-" {
+				value = value.Field(i)
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+
+	return value, true
+}
+
+// Setting is an effective value of the config, keyed like "services.0.address".
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// Settings lists the values of the config key by key, in the order of the config's fields.
+func (config Config) Settings() []Setting {
+	settings := make([]Setting, 0)
+	appendSettings(reflect.ValueOf(config), "", &settings)
+
+	return settings
+}
+
+func appendSettings(value reflect.Value, key string, settings *[]Setting) {
+	switch {
+	case value.Kind() == reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			name := strings.Split(value.Type().Field(i).Tag.Get("toml"), ",")[0]
+
+			// profiles are already applied, or not in use
+			if name == "" || name == "-" || name == "profile" && key == "" {
+				continue
+			}
+
+			appendSettings(value.Field(i), joinKey(key, name), settings)
+		}
+
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < value.Len(); i++ {
+			appendSettings(value.Index(i), joinKey(key, strconv.Itoa(i)), settings)
+		}
+
+	case value.Kind() == reflect.Map:
+		names := make([]string, 0, value.Len())
+		for _, name := range value.MapKeys() {
+			names = append(names, name.String())
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			appendSettings(value.MapIndex(reflect.ValueOf(name)), joinKey(key, name), settings)
+		}
+
+	default:
+		*settings = append(*settings, Setting{Key: key, Value: formatValue(value)})
+	}
+}
+
+// formatValue writes a value the way it's written in TOML.
+func formatValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return strconv.Quote(value.String())
+
+	case reflect.Slice:
+		values := make([]string, value.Len())
+		for i := range values {
+			values[i] = formatValue(value.Index(i))
+		}
+
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+
+	return fmt.Sprint(value.Interface())
+}
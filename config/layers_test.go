@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("GADGET_TEST_PORT", "8081")
+	t.Setenv("GADGET_TEST_EMPTY", "")
+
+	tests := map[string]string{
+		"localhost:${GADGET_TEST_PORT}":              "localhost:8081",
+		"localhost:${GADGET_TEST_UNSET:-8080}":       "localhost:8080",
+		"localhost:${GADGET_TEST_EMPTY:-8080}":       "localhost:8080",
+		"${GADGET_TEST_PORT:-1}/${GADGET_TEST_PORT}": "8081/8081",
+		"echo $${GADGET_TEST_PORT}":                  "echo ${GADGET_TEST_PORT}",
+		"no variables, not even ${":                  "no variables, not even ${",
+	}
+
+	for value, expected := range tests {
+		interpolated, missing := interpolate(value)
+		if interpolated != expected || len(missing) > 0 {
+			t.Errorf("Expected %q for %q, got %q missing %v", expected, value, interpolated, missing)
+		}
+	}
+
+	_, missing := interpolate("${GADGET_TEST_UNSET}")
+	if len(missing) != 1 || missing[0] != "GADGET_TEST_UNSET" {
+		t.Errorf("Expected GADGET_TEST_UNSET to be reported missing, got %v", missing)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	err := os.MkdirAll(filepath.Dir(GlobalFile()), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(GlobalFile(), []byte("listen_port = 4000\napp_address = \"localhost:4001\"\n\n[output]\ntimestamps = true\n"), 0644)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gadget.toml and gadget.local.toml
+	root, err := filepath.Abs("../_testdata/layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GADGET_OUTPUT_PREFIX", "false")
+
+	conf, origins, problems := LoadWithOrigins(filepath.Join(root, FileName), Flags{Name: "app"})
+	if len(problems) > 0 {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+
+	if conf.Address != "localhost:8080" || conf.ListenPort != 4200 || !conf.Output.Timestamps || conf.Output.Prefix || conf.Name != "app" {
+		t.Errorf("Expected each layer to override the one before, got %+v", conf)
+	}
+
+	expected := map[string]Origin{
+		"app_address":       {Line: 1},
+		"listen_port":       {File: filepath.Join(root, LocalFileName), Line: 1},
+		"output.timestamps": {File: GlobalFile(), Line: 5},
+		"output.prefix":     {Env: "GADGET_OUTPUT_PREFIX"},
+		"app_name":          {Flag: "binary"},
+		"listen_host":       {},
+	}
+
+	for key, origin := range expected {
+		if origins.Lookup(key) != origin {
+			t.Errorf("Expected %v to come from %+v, got %+v", key, origin, origins.Lookup(key))
+		}
+	}
+}
+
+func TestLoadFileInterpolation(t *testing.T) {
+	t.Setenv("GADGET_TEST_PORT", "8081")
+
+	path := filepath.Join(t.TempDir(), FileName)
+	content := `app_address = "localhost:${GADGET_TEST_PORT}"
+build_args = ["-tags", "${GADGET_TEST_UNSET}"]
+
+[[services]]
+name = "api"
+args = ["--port", "${GADGET_TEST_PORT}"]
+
+[commands.deploy]
+steps = ["deploy ${GADGET_TEST_PORT}"]
+
+[profile.ci]
+app_address = "ci:${GADGET_TEST_PORT}"
+`
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := Config{}
+	problems := conf.loadFile(path, "", Origins{})
+
+	if conf.Address != "localhost:8081" || conf.Services[0].Args[1] != "8081" ||
+		conf.Commands["deploy"].Steps[0] != "deploy 8081" || conf.Profiles["ci"]["app_address"] != "ci:8081" {
+		t.Errorf("Expected every string to be interpolated, got %+v", conf)
+	}
+
+	if len(problems) != 1 || problems[0].Line != 2 || !problems[0].Warning {
+		t.Errorf("Expected a warning for the unset variable on line 2, got %v", problems)
+	}
+
+}
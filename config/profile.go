@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// profileKeys are the keys a [profile.<name>] table can set: any key of the config, and the lists to append to
+// rather than replace.
+type profileKeys struct {
+	Append []string `toml:"append"`
+	Config
+}
+
+// ProfileNames are the profiles defined in the config, sorted.
+func (config Config) ProfileNames() []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// applyProfile overlays the [profile.<name>] table on the config. Tables merge key by key, so a profile only sets
+// what it names. Lists replace the base list, unless the key is listed in the profile's append, then the profile's
+// entries come after the base ones.
+func (config *Config) applyProfile(name string, projectDir string, lines Origins) Problems {
+	overlay, ok := config.Profiles[name]
+	if !ok {
+		message := fmt.Sprintf("unknown profile %q", name)
+		if names := config.ProfileNames(); len(names) > 0 {
+			message += ", the config has " + strings.Join(names, ", ")
+		} else {
+			message += ", the config has no [profile.<name>] tables"
+		}
+
+		return Problems{{Message: message}}
+	}
+
+	prefix := joinKey("profile", name)
+	problems := make(Problems, 0)
+
+	// lists named in append keep their base entries, copied since decoding reuses their arrays
+	base := make(map[string]reflect.Value)
+	appendKeys, _ := overlay["append"].([]any)
+	for _, key := range appendKeys {
+		key, _ := key.(string)
+
+		field, ok := fieldByKey(reflect.ValueOf(config).Elem(), key)
+		if !ok || strings.Contains(key, ".") || field.Kind() != reflect.Slice {
+			problems = append(problems, lines.problem(
+				joinKey(prefix, "append"),
+				fmt.Sprintf("profile %v can't append to %q, only to top level lists like exclude_dirs", name, key),
+			))
+
+			continue
+		}
+
+		base[key] = reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field)
+	}
+
+	values := make(map[string]any, len(overlay))
+	for key, value := range overlay {
+		if key != "append" {
+			values[key] = value
+		}
+	}
+
+	content, err := toml.Marshal(values)
+	if err == nil {
+		err = toml.Unmarshal(content, config)
+	}
+
+	if err != nil {
+		return append(problems, lines.problem(prefix, fmt.Sprintf("profile %v: %v", name, err)))
+	}
+
+	for key, entries := range base {
+		field, _ := fieldByKey(reflect.ValueOf(config).Elem(), key)
+		if _, set := overlay[key]; set {
+			field.Set(reflect.AppendSlice(entries, field))
+		} else {
+			field.Set(entries)
+		}
+	}
+
+	// paths are relative to the file with the profile, problems with values it set point at its lines
+	set := make(map[string]int)
+	for key := range lines {
+		if profileKey, ok := strings.CutPrefix(key, prefix+"."); ok {
+			set[profileKey] = lines[key].Line
+		}
+	}
+
+	baseDir := projectDir
+	if file := lines[prefix].File; file != "" {
+		baseDir = filepath.Dir(file)
+	}
+
+	config.resolvePaths(baseDir, set)
+	lines.set(set, Origin{File: lines[prefix].File})
+
+	config.Profile = name
+
+	return problems
+}
@@ -0,0 +1,706 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem is something wrong with a config file. Errors make the config unusable, warnings don't.
+type Problem struct {
+	// the config file, environment variable or flag with the problem, empty for the project's gadget.toml
+	File string
+	// line of the config file, 0 when the problem isn't about one line
+	Line    int
+	Message string
+	Warning bool
+}
+
+type Problems []Problem
+
+func (problems Problems) HasErrors() bool {
+	for _, problem := range problems {
+		if !problem.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format shows where the problem is, like file:line: message.
+func (problem Problem) Format(configPath string) string {
+	location := displayPath(configPath)
+	if problem.File != "" {
+		location = displayPath(problem.File)
+	}
+
+	if problem.Line > 0 {
+		location += ":" + strconv.Itoa(problem.Line)
+	}
+
+	return location + ": " + problem.Message
+}
+
+// displayPath is a path relative to the working directory when it's inside it.
+func displayPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		relative, err := filepath.Rel(wd, path)
+		if err == nil && !strings.HasPrefix(relative, "..") {
+			return relative
+		}
+	}
+
+	return path
+}
+
+// PrintProblems prints errors as danger and warnings as warnings.
+func PrintProblems(configPath string, problems Problems) {
+	for _, problem := range problems {
+		if problem.Warning {
+			cmd.PrintfWarning("%v", problem.Format(configPath))
+		} else {
+			cmd.PrintfDanger("%v", problem.Format(configPath))
+		}
+	}
+}
+
+// checkKeys walks the TOML document reporting syntax errors, keys Config doesn't have and values of the wrong type,
+// all at once. It returns the line each key is set on, keyed like "services.0.address".
+func checkKeys(content []byte) (Problems, map[string]int) {
+	problems := make(Problems, 0)
+	lines := make(map[string]int)
+	// number of [[table]] seen for each path
+	arrayTables := make(map[string]int)
+
+	parser := unstable.Parser{}
+	parser.Reset(content)
+
+	configType := reflect.TypeOf(Config{})
+	tableType := configType
+	tablePath := ""
+	tableKnown := true
+
+	for parser.NextExpression() {
+		expression := parser.Expression()
+		keys, line := keyParts(&parser, expression)
+
+		switch expression.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			tableType, tablePath, tableKnown = configType, "", true
+			isArray := false
+
+			for i, key := range keys {
+				fieldType, problem := keyType(tableType, tablePath, key)
+				if problem != "" {
+					problems = append(problems, Problem{Line: line, Message: problem})
+					tableKnown = false
+
+					break
+				}
+
+				tablePath = joinKey(tablePath, key)
+				tableType = fieldType
+				isArray = false
+
+				// [[services]] starts a new element, [services.x] continues the last one
+				if tableType.Kind() == reflect.Slice && tableType.Elem().Kind() == reflect.Struct {
+					if i == len(keys)-1 && expression.Kind == unstable.ArrayTable {
+						arrayTables[tablePath]++
+					}
+
+					tablePath = joinKey(tablePath, strconv.Itoa(max(arrayTables[tablePath]-1, 0)))
+					tableType = tableType.Elem()
+					isArray = true
+				}
+			}
+
+			if !tableKnown {
+				continue
+			}
+
+			lines[tablePath] = line
+
+			name := strings.Join(keys, ".")
+			if expression.Kind == unstable.ArrayTable && !isArray {
+				problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("%v isn't a list, use [%v]", name, name)})
+				tableKnown = false
+			} else if expression.Kind == unstable.Table && isArray {
+				problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("%v is a list, use [[%v]]", name, name)})
+				tableKnown = false
+			} else if tableType.Kind() != reflect.Struct && tableType.Kind() != reflect.Map {
+				problems = append(problems, Problem{
+					Line:    line,
+					Message: fmt.Sprintf("%v should be %v, not a table", name, describeType(tableType)),
+				})
+				tableKnown = false
+			}
+
+		case unstable.KeyValue:
+			if tableKnown {
+				problems = append(problems, checkKeyValue(&parser, tableType, tablePath, keys, expression.Value(), line, lines)...)
+			}
+		}
+	}
+
+	if parser.Error() != nil {
+		problems = append(problems, decodeProblem(toml.Unmarshal(content, &map[string]any{})))
+	}
+
+	return problems, lines
+}
+
+func checkKeyValue(parser *unstable.Parser, valueType reflect.Type, path string, keys []string, value *unstable.Node, line int, lines map[string]int) Problems {
+	for _, key := range keys {
+		fieldType, problem := keyType(valueType, path, key)
+		if problem != "" {
+			return Problems{{Line: line, Message: problem}}
+		}
+
+		path = joinKey(path, key)
+		valueType = fieldType
+	}
+
+	lines[path] = line
+
+	return checkValue(parser, valueType, path, value, line, lines)
+}
+
+func checkValue(parser *unstable.Parser, valueType reflect.Type, path string, value *unstable.Node, line int, lines map[string]int) Problems {
+	matches := false
+
+	switch valueType.Kind() {
+	case reflect.String:
+		matches = value.Kind == unstable.String
+
+	case reflect.Int, reflect.Int64:
+		matches = value.Kind == unstable.Integer
+
+	case reflect.Float64:
+		matches = value.Kind == unstable.Float || value.Kind == unstable.Integer
+
+	case reflect.Bool:
+		matches = value.Kind == unstable.Bool
+
+	case reflect.Slice:
+		if value.Kind != unstable.Array {
+			break
+		}
+
+		problems := make(Problems, 0)
+		children := value.Children()
+		for i := 0; children.Next(); i++ {
+			problems = append(problems, checkValue(parser, valueType.Elem(), joinKey(path, strconv.Itoa(i)), children.Node(), line, lines)...)
+		}
+
+		return problems
+
+	case reflect.Struct, reflect.Map:
+		if value.Kind != unstable.InlineTable {
+			break
+		}
+
+		problems := make(Problems, 0)
+		children := value.Children()
+		for children.Next() {
+			keyValue := children.Node()
+			keys, keyLine := keyParts(parser, keyValue)
+			problems = append(problems, checkKeyValue(parser, valueType, path, keys, keyValue.Value(), keyLine, lines)...)
+		}
+
+		return problems
+	}
+
+	if matches {
+		return nil
+	}
+
+	return Problems{{
+		Line:    line,
+		Message: fmt.Sprintf("%v should be %v, not %v", displayKey(path), describeType(valueType), describeValue(value)),
+	}}
+}
+
+// keyType is the type of the value of key in a value of parentType.
+func keyType(parentType reflect.Type, parentPath string, key string) (reflect.Type, string) {
+	switch parentType.Kind() {
+	case reflect.Map:
+		return parentType.Elem(), ""
+
+	case reflect.Struct:
+		fields := tomlFields(parentType)
+
+		if fieldType, ok := fields[key]; ok {
+			// profiles overlay the config, so they have its keys
+			if key == "profile" && parentType == reflect.TypeOf(Config{}) {
+				if parentPath != "" {
+					return nil, "profiles can't be nested in profiles"
+				}
+
+				return reflect.TypeOf(map[string]profileKeys{}), ""
+			}
+
+			return fieldType, ""
+		}
+
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		problem := fmt.Sprintf("unknown key %q", key)
+		if parentPath != "" {
+			problem += " in " + displayKey(parentPath)
+		}
+
+		if suggestion := closestName(key, names); suggestion != "" {
+			problem += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+
+		return nil, problem
+	}
+
+	return nil, fmt.Sprintf("%v should be %v, not a table", displayKey(parentPath), describeType(parentType))
+}
+
+// tomlFields are the TOML keys of a struct and their types, including those of embedded structs.
+func tomlFields(structType reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("toml"), ",")[0]
+
+		if field.Anonymous && name == "" {
+			for embeddedName, embeddedType := range tomlFields(field.Type) {
+				fields[embeddedName] = embeddedType
+			}
+
+			continue
+		}
+
+		if name != "" && name != "-" {
+			fields[name] = field.Type
+		}
+	}
+
+	return fields
+}
+
+// keyParts returns the parts of a dotted key, and the line it's on.
+func keyParts(parser *unstable.Parser, expression *unstable.Node) ([]string, int) {
+	keys := make([]string, 0)
+	line := 0
+
+	iterator := expression.Key()
+	for iterator.Next() {
+		keyNode := iterator.Node()
+		keys = append(keys, string(keyNode.Data))
+
+		if line == 0 {
+			line = parser.Shape(keyNode.Raw).Start.Line
+		}
+	}
+
+	return keys, line
+}
+
+func joinKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// displayKey drops list indexes from a key path, services.0.address is services.address.
+func displayKey(path string) string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func describeType(valueType reflect.Type) string {
+	switch valueType.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		switch valueType.Elem().Kind() {
+		case reflect.String:
+			return "a list of strings"
+		case reflect.Struct:
+			return "a list of tables"
+		}
+
+		return "a list"
+	}
+
+	return "a table"
+}
+
+func describeValue(value *unstable.Node) string {
+	switch value.Kind {
+	case unstable.String:
+		return "a string"
+	case unstable.Integer:
+		return "a whole number"
+	case unstable.Float:
+		return "a decimal number"
+	case unstable.Bool:
+		return "true or false"
+	case unstable.Array:
+		return "a list"
+	case unstable.InlineTable:
+		return "a table"
+	}
+
+	return "a date"
+}
+
+// closestName is the name most like key, for typos. It's empty when no name is close enough.
+func closestName(key string, names []string) string {
+	closest := ""
+	closestDistance := max(2, len(key)/3) + 1
+
+	for _, name := range names {
+		distance := editDistance(key, name)
+		if distance < closestDistance {
+			closest = name
+			closestDistance = distance
+		}
+	}
+
+	return closest
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func decodeProblem(err error) Problem {
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, _ := decodeErr.Position()
+
+		return Problem{Line: line, Message: strings.TrimPrefix(decodeErr.Error(), "toml: ")}
+	}
+
+	return Problem{Message: fmt.Sprintf("%v", err)}
+}
+
+// validate checks the values of a decoded config with relative paths already resolved. lines are where keys are
+// set, so problems point at them.
+func (config Config) validate(lines Origins) Problems {
+	problems := make(Problems, 0)
+
+	if stat, err := os.Stat(config.Path); err != nil || !stat.IsDir() {
+		problems = append(problems, lines.warning("app_path", fmt.Sprintf("app_path %v isn't a directory", config.Path)))
+	}
+
+	pathLists := []struct {
+		key     string
+		paths   []string
+		include bool
+	}{
+		{"exclude_dirs", config.ExcludeDirs, false},
+		{"exclude_files", config.ExcludeFiles, false},
+		{"include_dirs", config.IncludeDirs, true},
+		{"include_files", config.IncludeFiles, true},
+	}
+
+	for _, list := range pathLists {
+		for _, path := range list.paths {
+			if path == "" {
+				problems = append(problems, lines.problem(list.key, list.key+" has an empty path"))
+
+				continue
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				problems = append(problems, lines.warning(list.key, fmt.Sprintf("%v: %v doesn't exist", list.key, path)))
+			}
+		}
+	}
+
+	for _, list := range []struct {
+		key    string
+		values []string
+	}{
+		{"exclude_exts", config.ExcludeExts},
+		{"exclude_prefix", config.ExcludePrefix},
+	} {
+		for _, value := range list.values {
+			if value == "" {
+				problems = append(problems, lines.problem(list.key, list.key+" has an empty value, it would match every file"))
+			}
+		}
+	}
+
+	type buildPackage struct {
+		key string
+		pkg string
+	}
+
+	packages := []buildPackage{{"build_package", config.BuildPackage}}
+	for i, service := range config.Services {
+		packages = append(packages, buildPackage{"services." + strconv.Itoa(i) + ".build_package", service.BuildPackage})
+	}
+
+	// packages given as paths must be there, patterns and import paths are left to go build
+	for _, build := range packages {
+		if !strings.HasPrefix(build.pkg, ".") || strings.Contains(build.pkg, "...") {
+			continue
+		}
+
+		if stat, err := os.Stat(filepath.Join(config.Path, build.pkg)); err != nil || !stat.IsDir() {
+			problems = append(problems, lines.warning(build.key, fmt.Sprintf("%v %v isn't a directory of app_path", displayKey(build.key), build.pkg)))
+		}
+	}
+
+	problems = append(problems, config.validateVariant(lines)...)
+	problems = append(problems, config.validateUnits(lines)...)
+	problems = append(problems, config.validatePorts(lines)...)
+	problems = append(problems, config.validatePprof(lines)...)
+
+	return problems
+}
+
+// validatePprof checks where profiles are fetched from. A pprof address is usually an app's, it isn't checked for
+// ports used twice.
+func (config Config) validatePprof(lines Origins) Problems {
+	problems := make(Problems, 0)
+
+	type pprofAddress struct {
+		key     string
+		address string
+	}
+
+	addresses := []pprofAddress{{"pprof.address", config.Pprof.Address}}
+	for i, service := range config.Services {
+		addresses = append(addresses, pprofAddress{"services." + strconv.Itoa(i) + ".pprof_address", service.PprofAddress})
+	}
+
+	for _, address := range addresses {
+		if address.address == "" {
+			continue
+		}
+
+		if _, err := addressPort(address.address); err != nil {
+			problems = append(problems, lines.problem(address.key, fmt.Sprintf("%v %q is invalid: %v", displayKey(address.key), address.address, err)))
+		}
+	}
+
+	if !strings.HasPrefix(config.Pprof.Path, "/") {
+		problems = append(problems, lines.problem("pprof.path", fmt.Sprintf("pprof.path %q should start with /", config.Pprof.Path)))
+	}
+
+	if config.Pprof.Top < 1 {
+		problems = append(problems, lines.problem("pprof.top", fmt.Sprintf("pprof.top %v should be at least 1", config.Pprof.Top)))
+	}
+
+	return problems
+}
+
+func (config Config) validateUnits(lines Origins) Problems {
+	problems := make(Problems, 0)
+
+	names := make(map[string]bool)
+	for i, service := range config.Services {
+		key := "services." + strconv.Itoa(i)
+
+		if service.Name == "" {
+			problems = append(problems, lines.problem(key, fmt.Sprintf("service %v is missing a name", i+1)))
+
+			continue
+		}
+
+		if names[service.Name] {
+			problems = append(problems, lines.problem(key+".name", fmt.Sprintf("service name %v is used more than once", service.Name)))
+		}
+
+		names[service.Name] = true
+	}
+
+	for i, process := range config.Processes {
+		key := "process." + strconv.Itoa(i)
+
+		if process.Name == "" || process.Command == "" {
+			problems = append(problems, lines.problem(key, fmt.Sprintf("process %v needs a name and a command", i+1)))
+
+			continue
+		}
+
+		if names[process.Name] {
+			problems = append(problems, lines.problem(key+".name", fmt.Sprintf("process name %v is already used by another service or process", process.Name)))
+		}
+
+		names[process.Name] = true
+
+		switch process.Restart {
+		case "", "no", "on-failure", "always":
+		default:
+			problems = append(problems, lines.problem(key+".restart", fmt.Sprintf("process %v has an unknown restart policy %q, use no, on-failure or always", process.Name, process.Restart)))
+		}
+
+		if _, ok := cmd.NamedColors[process.Color]; process.Color != "" && !ok {
+			problems = append(problems, lines.warning(key+".color", fmt.Sprintf("process %v has an unknown color %q, use red, green, yellow, blue, magenta or cyan", process.Name, process.Color)))
+		}
+	}
+
+	if len(config.Services) > 0 && len(config.DependsOn) > 0 {
+		problems = append(problems, lines.problem("depends_on", "depends_on is for the app without [[services]], set it on each service instead"))
+	}
+
+	if problems.HasErrors() {
+		return problems
+	}
+
+	_, err := config.StartOrder()
+	if err != nil {
+		problems = append(problems, Problem{Message: fmt.Sprintf("invalid depends_on: %v", err)})
+	}
+
+	return problems
+}
+
+// validatePorts checks addresses, and that no two of them or of the debugger ports use the same port.
+func (config Config) validatePorts(lines Origins) Problems {
+	problems := make(Problems, 0)
+
+	type portUse struct {
+		key   string
+		label string
+		port  int
+		// a debugger port; services without their own debug_port share listen_port, gadget finds them another port
+		debugger bool
+	}
+
+	uses := make([]portUse, 0)
+
+	addAddress := func(key string, label string, address string) {
+		if address == "" {
+			return
+		}
+
+		port, err := addressPort(address)
+		if err != nil {
+			problems = append(problems, lines.problem(key, fmt.Sprintf("%v %q is invalid: %v", label, address, err)))
+
+			return
+		}
+
+		uses = append(uses, portUse{key: key, label: label, port: port})
+	}
+
+	addPort := func(key string, label string, port int) {
+		if port < 0 || port > 65535 {
+			problems = append(problems, lines.problem(key, fmt.Sprintf("%v %v isn't a port, use 1 to 65535", label, port)))
+
+			return
+		}
+
+		if port != 0 {
+			uses = append(uses, portUse{key: key, label: label, port: port, debugger: true})
+		}
+	}
+
+	// with services, app_address isn't used
+	if len(config.Services) == 0 {
+		addAddress("app_address", "app_address", config.Address)
+	}
+
+	addPort("listen_port", "listen_port", config.ListenPort)
+
+	for i, service := range config.Services {
+		key := "services." + strconv.Itoa(i)
+		addAddress(key+".address", "the address of service "+service.Name, service.Address)
+		addPort(key+".debug_port", "the debug_port of service "+service.Name, service.DebugPort)
+	}
+
+	for i, process := range config.Processes {
+		addAddress("process."+strconv.Itoa(i)+".address", "the address of process "+process.Name, process.Address)
+	}
+
+	if config.Control.HTTP != "" {
+		addAddress("control.http", "control.http", config.Control.HTTP)
+	}
+
+	for i, use := range uses {
+		for _, earlier := range uses[:i] {
+			if use.port != earlier.port {
+				continue
+			}
+
+			if use.debugger && earlier.debugger && (use.key == "listen_port" || earlier.key == "listen_port") {
+				continue
+			}
+
+			problems = append(problems, lines.problem(use.key, fmt.Sprintf("port %v of %v is already used by %v", use.port, use.label, earlier.label)))
+		}
+	}
+
+	return problems
+}
+
+// addressPort checks an address like localhost:8080 and returns its port.
+func addressPort(address string) (int, error) {
+	_, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, errors.New("use host:port, like localhost:8080")
+	}
+
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.New("the port should be 1 to 65535")
+	}
+
+	return port, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadReportsKeyProblems(t *testing.T) {
+	// keep the user's ~/.clanko-gadget-cli/config.toml out of the test
+	t.Setenv("HOME", t.TempDir())
+
+	_, problems := Load("../_testdata/invalid.toml", Flags{})
+
+	expected := []Problem{
+		{Line: 2, Message: `unknown key "exlude_dirs", did you mean "exclude_dirs"?`},
+		{Line: 3, Message: "listen_port should be a whole number, not a string"},
+		{Line: 6, Message: `unknown key "prefx" in output, did you mean "prefix"?`},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %v problems, got %v", len(expected), problems)
+	}
+
+	for i, problem := range expected {
+		if problems[i] != problem {
+			t.Errorf("Expected %v, got %v", problem, problems[i])
+		}
+	}
+}
+
+func TestLoadReportsValueProblems(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	_, problems := Load("../_testdata/conflicts.toml", Flags{})
+
+	expected := map[int]string{
+		1:  "exclude_dirs has an empty path",
+		2:  `unknown variant "fast"`,
+		10: "port 8080 of the address of service worker is already used by the address of service api",
+		15: `unknown restart policy "sometimes"`,
+		18: `control.http "localhost" is invalid`,
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %v problems, got %v", len(expected), problems)
+	}
+
+	for _, problem := range problems {
+		if !strings.Contains(problem.Message, expected[problem.Line]) || problem.Warning {
+			t.Errorf("Unexpected problem on line %v: %v", problem.Line, problem.Message)
+		}
+	}
+}
+
+func TestClosestName(t *testing.T) {
+	names := []string{"exclude_dirs", "exclude_files", "include_dirs"}
+
+	if closestName("exlude_dirs", names) != "exclude_dirs" {
+		t.Errorf("Expected exclude_dirs for a typo of it")
+	}
+
+	if closestName("services", names) != "" {
+		t.Errorf("Expected no suggestion for an unrelated key")
+	}
+}
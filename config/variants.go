@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VariantNames are the ways gadget builds services, in the order they're listed.
+var VariantNames = []string{"debug", "race", "cover", "release"}
+
+// noOptimizations turns off optimizations and inlining, for the debugger.
+const noOptimizations = "-gcflags=all=-N -l"
+
+// variantArgs are the go build arguments each variant adds before build_args. The variants run with the debugger
+// attached build without optimizations.
+var variantArgs = map[string][]string{
+	"debug": {noOptimizations},
+	"race":  {"-race", noOptimizations},
+	// the app writes coverage data to GOCOVERDIR when it exits
+	"cover": {"-cover", noOptimizations},
+	// optimized and stripped, run without a debugger
+	"release": {"-trimpath", "-ldflags=-s -w"},
+}
+
+// IsVariant reports whether name is one of VariantNames.
+func IsVariant(name string) bool {
+	_, ok := variantArgs[name]
+
+	return ok
+}
+
+// BuildFlags are the go build arguments of the config's variant, followed by build_args. A flag build_args sets
+// replaces the variant's rather than being passed twice.
+func (config Config) BuildFlags() []string {
+	flags := make([]string, 0)
+	for _, arg := range variantArgs[config.Variant] {
+		if config.buildArg(flagName(arg)) == "" {
+			flags = append(flags, arg)
+		}
+	}
+
+	return append(flags, config.BuildArgs...)
+}
+
+// Debuggable reports whether the variant runs with the debugger attached.
+func (config Config) Debuggable() bool {
+	return config.Variant != "release"
+}
+
+// buildArg is the argument of build_args setting the flag name, empty when none does.
+func (config Config) buildArg(name string) string {
+	for i, arg := range config.BuildArgs {
+		if flagName(arg) != name {
+			continue
+		}
+
+		// -gcflags "all=-N -l"
+		if !strings.Contains(arg, "=") && i+1 < len(config.BuildArgs) {
+			return arg + "=" + config.BuildArgs[i+1]
+		}
+
+		return arg
+	}
+
+	return ""
+}
+
+// flagName is the name of a go build flag argument, like gcflags for -gcflags=all=-N, empty for a value.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+	return name
+}
+
+func (config Config) validateVariant(lines Origins) Problems {
+	if !IsVariant(config.Variant) {
+		return Problems{lines.problem("variant", fmt.Sprintf("unknown variant %q, use one of %v", config.Variant, strings.Join(VariantNames, ", ")))}
+	}
+
+	problems := make(Problems, 0)
+	for _, arg := range variantArgs[config.Variant] {
+		replaced := config.buildArg(flagName(arg))
+		if replaced != "" && strings.TrimLeft(replaced, "-") != strings.TrimLeft(arg, "-") {
+			problems = append(problems, lines.warning("build_args", fmt.Sprintf("build_args sets %v, replacing %v of the %v variant", replaced, arg, config.Variant)))
+		}
+	}
+
+	return problems
+}
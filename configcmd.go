@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"os"
+	"text/tabwriter"
+)
+
+const configUsage = "Usage: gadget config check [-strict] | gadget config show [--origin]"
+
+// runConfigCommand runs gadget config, returning the exit code.
+//
+//	gadget config check [-strict]
+//	gadget config show [--origin]
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		cmd.PrintfWarning(configUsage)
+
+		return 2
+	}
+
+	switch args[0] {
+	case "check":
+		flags := flag.NewFlagSet("gadget config check", flag.ContinueOnError)
+		strict := flags.Bool("strict", false, "Fail on warnings too, like paths that don't exist")
+
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return 2
+		}
+
+		return checkConfig(configFile, *strict)
+
+	case "show":
+		flags := flag.NewFlagSet("gadget config show", flag.ContinueOnError)
+		origin := flags.Bool("origin", false, "Show where each value came from")
+
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return 2
+		}
+
+		return showConfig(configFile, *origin)
+	}
+
+	cmd.PrintfWarning(configUsage)
+
+	return 2
+}
+
+// checkConfig prints every problem of a config file, for CI. It fails on errors, and with strict on warnings too.
+func checkConfig(configPath string, strict bool) int {
+	if _, err := os.Stat(configPath); err != nil {
+		cmd.PrintfDanger("No config file at %v", configPath)
+
+		return 1
+	}
+
+	_, problems := config.Load(configPath, configFlags())
+
+	config.PrintProblems(configPath, problems)
+
+	errorCount, warningCount := 0, 0
+	for _, problem := range problems {
+		if problem.Warning {
+			warningCount++
+		} else {
+			errorCount++
+		}
+	}
+
+	if len(problems) == 0 {
+		cmd.PrintfSuccess("%v: no problems found", configPath)
+
+		return 0
+	}
+
+	cmd.PrintfInfo("%v errors, %v warnings", errorCount, warningCount)
+
+	if errorCount > 0 || (strict && warningCount > 0) {
+		return 1
+	}
+
+	return 0
+}
+
+// showConfig prints the effective config after every layer, and with origin where each value came from.
+func showConfig(configPath string, origin bool) int {
+	conf, origins, problems := config.LoadWithOrigins(configPath, configFlags())
+
+	config.PrintProblems(configPath, problems)
+
+	if problems.HasErrors() {
+		return 1
+	}
+
+	var buffer bytes.Buffer
+
+	writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+
+	if conf.Profile != "" {
+		fmt.Fprintf(writer, "# profile %v\n", conf.Profile)
+	}
+
+	for _, setting := range conf.Settings() {
+		if origin {
+			fmt.Fprintf(writer, "%v = %v\t# %v\n", setting.Key, setting.Value, origins.Lookup(setting.Key).Describe(configPath))
+		} else {
+			fmt.Fprintf(writer, "%v = %v\n", setting.Key, setting.Value)
+		}
+	}
+
+	_ = writer.Flush()
+
+	cmd.Write(buffer.String())
+
+	return 0
+}
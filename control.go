@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controlRequest asks the session to run a shell command, e.g. {"command": "restart", "args": ["api"]}. The status,
+// logs and events requests are answered by the API itself.
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// for logs, the number of kept lines to send and whether to keep sending lines as they're printed
+	Limit  int  `json:"limit,omitempty"`
+	Follow bool `json:"follow,omitempty"`
+}
+
+type controlResponse struct {
+	OK     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Status *sessionStatus `json:"status,omitempty"`
+}
+
+// logLine is a line printed by the app, the debugger or a process, sent for a logs request.
+type logLine struct {
+	Source string    `json:"source"`
+	Line   string    `json:"line"`
+	Stderr bool      `json:"stderr,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// sessionRuntime is written next to the socket while a session runs, so gadget ctl can find the session of a project.
+type sessionRuntime struct {
+	Pid     int       `json:"pid"`
+	Socket  string    `json:"socket"`
+	HTTP    string    `json:"http,omitempty"`
+	AppPath string    `json:"app_path"`
+	Config  string    `json:"config"`
+	Started time.Time `json:"started"`
+}
+
+// commandMu keeps commands from the shell and the control API, rebuilds and config reloads from running at the same
+// time. It guards the shell's services, which a reload replaces.
+var commandMu sync.Mutex
+
+// controlServer serves the control API on a Unix socket, and optionally over HTTP on a localhost address.
+type controlServer struct {
+	gsh         *gadgetShell
+	socketPath  string
+	runtimePath string
+	listener    net.Listener
+	httpServer  *http.Server
+}
+
+// startControl starts the control API, or returns nil when it's disabled or can't start.
+func startControl(gsh *gadgetShell, conf config.Config) *controlServer {
+	if !conf.Control.Enabled {
+		return nil
+	}
+
+	runtimePath, err := sessionRuntimePath(conf)
+	if err != nil {
+		cmd.PrintfWarning("Control API not started: %v", err)
+
+		return nil
+	}
+
+	socketPath := conf.Control.Socket
+	if socketPath == "" {
+		socketPath = strings.TrimSuffix(runtimePath, ".json") + ".sock"
+	}
+
+	listener, err := listenControlSocket(socketPath)
+	if err != nil {
+		cmd.PrintfWarning("Control API not started: %v", err)
+
+		return nil
+	}
+
+	server := &controlServer{
+		gsh:         gsh,
+		socketPath:  socketPath,
+		runtimePath: runtimePath,
+		listener:    listener,
+	}
+
+	go server.serveSocket()
+
+	if verbose > 0 {
+		cmd.PrintfInfo("Control API on %v", socketPath)
+	}
+
+	if conf.Control.HTTP != "" {
+		server.startHTTP(conf.Control.HTTP)
+	}
+
+	session := sessionRuntime{
+		Pid:     os.Getpid(),
+		Socket:  socketPath,
+		AppPath: conf.Path,
+		Config:  configFile,
+		Started: time.Now(),
+	}
+
+	if server.httpServer != nil {
+		session.HTTP = conf.Control.HTTP
+	}
+
+	content, err := json.MarshalIndent(session, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(runtimePath), 0700)
+	}
+
+	if err == nil {
+		err = os.WriteFile(runtimePath, content, 0600)
+	}
+
+	if err != nil {
+		cmd.PrintfWarning("Failed to write %v, gadget ctl won't find the session: %v", runtimePath, err)
+	}
+
+	return server
+}
+
+// sessionRuntimePath is the runtime file of the project's session in ~/.clanko-gadget-cli/run. Projects are told apart
+// by a hash of their path.
+func sessionRuntimePath(conf config.Config) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(conf.Path))
+
+	return filepath.Join(home, gadgetCliConfigDir, "run", hex.EncodeToString(sum[:])[:16]+".json"), nil
+}
+
+// listenControlSocket listens on a socket only the user can connect to. A socket left behind by a session that
+// ended without cleaning up is replaced, one of a running session isn't.
+func listenControlSocket(socketPath string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(socketPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil {
+		_ = conn.Close()
+
+		return nil, errors.New("another gadget session is using " + socketPath)
+	}
+
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		_ = listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// close stops serving and removes the socket. It's safe to call on nil.
+func (s *controlServer) close() {
+	if s == nil {
+		return
+	}
+
+	_ = s.listener.Close()
+	_ = os.Remove(s.socketPath)
+	_ = os.Remove(s.runtimePath)
+
+	if s.httpServer != nil {
+		_ = s.httpServer.Close()
+	}
+}
+
+// serveSocket reads one JSON request per line and writes one JSON response per line. After an events request the
+// connection streams events until it's closed, after a logs request it streams lines.
+func (s *controlServer) serveSocket() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *controlServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var request controlRequest
+			decodeErr := json.Unmarshal(line, &request)
+
+			switch {
+			case decodeErr != nil:
+				_ = encoder.Encode(controlResponse{Error: "invalid request: " + decodeErr.Error()})
+
+			case request.Command == "events" || request.Command == "logs":
+				closed := make(chan struct{})
+				go func() {
+					_, _ = io.Copy(io.Discard, reader)
+					close(closed)
+				}()
+
+				if request.Command == "events" {
+					streamEvents(encoder, closed, nil)
+				} else {
+					streamLogs(encoder, closed, nil, request)
+				}
+
+				return
+
+			default:
+				if encoder.Encode(s.handle(request)) != nil {
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *controlServer) handle(request controlRequest) controlResponse {
+	if request.Command == "status" {
+		commandMu.Lock()
+		status := s.gsh.status()
+		commandMu.Unlock()
+
+		return controlResponse{OK: true, Status: &status}
+	}
+
+	err := s.gsh.runControlCommand(request.Command, request.Args)
+	if err != nil {
+		return controlResponse{Error: err.Error()}
+	}
+
+	return controlResponse{OK: true}
+}
+
+// streamEvents writes events as they're published until the client goes away.
+func streamEvents(encoder *json.Encoder, closed <-chan struct{}, flush func()) {
+	subscriber, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case published := <-subscriber:
+			if encoder.Encode(published) != nil {
+				return
+			}
+
+			if flush != nil {
+				flush()
+			}
+		}
+	}
+}
+
+// streamLogs writes the last kept lines, of one source when the request names one. When following, it keeps writing
+// lines as they're printed until the client goes away.
+func streamLogs(encoder *json.Encoder, closed <-chan struct{}, flush func(), request controlRequest) {
+	kept, follower, stop := scrollback.follow()
+	defer stop()
+
+	keep := func(entry logEntry) bool {
+		return len(request.Args) == 0 || entry.source == request.Args[0]
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultLogsLimit
+	}
+
+	for _, entry := range filterEntries(kept, keep, limit) {
+		if encoder.Encode(newLogLine(entry)) != nil {
+			return
+		}
+	}
+
+	if flush != nil {
+		flush()
+	}
+
+	if !request.Follow {
+		return
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case entry := <-follower:
+			if !keep(entry) {
+				continue
+			}
+
+			if encoder.Encode(newLogLine(entry)) != nil {
+				return
+			}
+
+			if flush != nil {
+				flush()
+			}
+		}
+	}
+}
+
+func newLogLine(entry logEntry) logLine {
+	return logLine{
+		Source: entry.source,
+		Line:   entry.line,
+		Stderr: entry.isStderr,
+		Time:   entry.printedAt,
+	}
+}
+
+// startHTTP serves the same API over HTTP, streaming logs and events as one JSON object per line. It only listens on
+// localhost, anything else on the network could drive the session.
+func (s *controlServer) startHTTP(address string) {
+	if !isLoopback(address) {
+		cmd.PrintfWarning("Control API not served on %v, only localhost addresses are allowed", address)
+
+		return
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /command", func(writer http.ResponseWriter, request *http.Request) {
+		// a page can only send JSON after a preflight, which gadget doesn't answer
+		mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			http.Error(writer, "Content-Type should be application/json", http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		var controlReq controlRequest
+		err := json.NewDecoder(request.Body).Decode(&controlReq)
+		if err != nil {
+			writeControlResponse(writer, controlResponse{Error: "invalid request: " + err.Error()})
+
+			return
+		}
+
+		writeControlResponse(writer, s.handle(controlReq))
+	})
+
+	mux.HandleFunc("GET /status", func(writer http.ResponseWriter, request *http.Request) {
+		writeControlResponse(writer, s.handle(controlRequest{Command: "status"}))
+	})
+
+	mux.HandleFunc("GET /events", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+		writer.WriteHeader(http.StatusOK)
+
+		flush := func() {}
+		if flusher, ok := writer.(http.Flusher); ok {
+			flush = flusher.Flush
+			flush()
+		}
+
+		streamEvents(json.NewEncoder(writer), request.Context().Done(), flush)
+	})
+
+	mux.HandleFunc("GET /logs", func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+
+		logsRequest := controlRequest{Command: "logs", Follow: query.Get("follow") == "true"}
+		if query.Get("source") != "" {
+			logsRequest.Args = []string{query.Get("source")}
+		}
+
+		limit, err := strconv.Atoi(query.Get("n"))
+		if err == nil {
+			logsRequest.Limit = limit
+		}
+
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+		writer.WriteHeader(http.StatusOK)
+
+		flush := func() {}
+		if flusher, ok := writer.(http.Flusher); ok {
+			flush = flusher.Flush
+		}
+
+		streamLogs(json.NewEncoder(writer), request.Context().Done(), flush, logsRequest)
+	})
+
+	s.httpServer = &http.Server{Addr: address, Handler: localOnly(mux)}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		cmd.PrintfWarning("Control API not served over HTTP: %v", err)
+		s.httpServer = nil
+
+		return
+	}
+
+	go func() {
+		_ = s.httpServer.Serve(listener)
+	}()
+
+	cmd.PrintfInfo("Control API on http://%v", address)
+}
+
+// localOnly rejects requests made by web pages: those with an Origin, and those for a host name other than localhost,
+// which a DNS rebinding page would send to read the replies.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "80")
+		}
+
+		if request.Header.Get("Origin") != "" || !isLoopback(host) {
+			http.Error(writer, "only local clients can use the control API", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func writeControlResponse(writer http.ResponseWriter, response controlResponse) {
+	writer.Header().Set("Content-Type", "application/json")
+	if !response.OK {
+		writer.WriteHeader(http.StatusBadRequest)
+	}
+
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// noInput is the input of commands run through the control API, they can't prompt for anything.
+type noInput struct{}
+
+func (noInput) readLine(prompt string) (string, error) {
+	return "", errors.New("no input through the control API")
+}
+
+// controlCommands are the gadget commands the control API runs. The others, like make, plugins and the commands of
+// gadget.toml, can read the terminal or prompt, and would take it over while the client waits.
+var controlCommands = []string{"build", "run", "debug", "dev", "restart", "watch", "unwatch", "status", "logs", "variant",
+	"profile", "coverage", "pprof"}
+
+// runControlCommand runs a gadget command for the control API. Shell commands and interactive commands can't be run
+// through the API.
+func (gsh *gadgetShell) runControlCommand(name string, args []string) error {
+	if !gsh.hasCommand(name) {
+		return errors.New("unknown command: " + name)
+	}
+
+	if !slices.Contains(controlCommands, name) {
+		return fmt.Errorf("%v can't be run through the control API, use one of %v", name, strings.Join(controlCommands, ", "))
+	}
+
+	registered := gsh.getCommand(name)
+
+	err := registered.info().validateArgs(args)
+	if err != nil {
+		return err
+	}
+
+	commandMu.Lock()
+	defer commandMu.Unlock()
+
+	cmd.PrintfInfo("Control: %v", strings.TrimSpace(name+" "+strings.Join(args, " ")))
+
+	// commands report failures as events, the client learns of them from the reply
+	subscriber, unsubscribe := events.subscribe()
+	registered.execute(noInput{}, args)
+	unsubscribe()
+
+	return commandFailure(subscriber)
+}
+
+// commandFailure is the error for the failures published while a command ran, nil without any.
+func commandFailure(published chan event) error {
+	failures := make([]string, 0)
+
+	for {
+		select {
+		case failed := <-published:
+			switch failed.Type {
+			case "build_failed":
+				failures = append(failures, "build of "+failed.Source+" failed")
+			case "command_failed":
+				failures = append(failures, failed.Message)
+			}
+
+		default:
+			if len(failures) == 0 {
+				return nil
+			}
+
+			return errors.New(strings.Join(failures, ", "))
+		}
+	}
+}
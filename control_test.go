@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsLoopback(t *testing.T) {
+	cases := map[string]bool{
+		"localhost:3812": true,
+		"127.0.0.1:3812": true,
+		"[::1]:3812":     true,
+		"0.0.0.0:3812":   false,
+		":3812":          false,
+		"10.0.0.4:3812":  false,
+		"localhost":      false,
+	}
+
+	for address, expected := range cases {
+		if isLoopback(address) != expected {
+			t.Errorf("Expected isLoopback(%q) to be %v", address, expected)
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := &eventBus{subscribers: make(map[chan event]bool)}
+
+	subscriber, unsubscribe := bus.subscribe()
+	bus.publish("build", "api", "")
+
+	received := <-subscriber
+	if received.Type != "build" || received.Source != "api" {
+		t.Errorf("Expected the build event of api, got %v", received)
+	}
+
+	unsubscribe()
+	bus.publish("start", "api", "")
+
+	if len(subscriber) != 0 {
+		t.Errorf("Expected no events after unsubscribing")
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	bus := &eventBus{subscribers: make(map[chan event]bool)}
+
+	subscriber, unsubscribe := bus.subscribe()
+	bus.publish("build", "api", "")
+	bus.publish("start", "api", "")
+	unsubscribe()
+
+	if err := commandFailure(subscriber); err != nil {
+		t.Errorf("Expected no failure, got %v", err)
+	}
+
+	subscriber, unsubscribe = bus.subscribe()
+	bus.publish("build_failed", "api", "undefined: x")
+	bus.publish("command_failed", "deploy", "deploy stopped at step 2")
+	unsubscribe()
+
+	err := commandFailure(subscriber)
+	if err == nil || err.Error() != "build of api failed, deploy stopped at step 2" {
+		t.Errorf("Expected the failed build and command, got %v", err)
+	}
+}
+
+func TestRunControlCommandRefusesInteractiveCommands(t *testing.T) {
+	gsh := &gadgetShell{config: config.Config{Commands: map[string]config.Command{"deploy": {Steps: []string{"build"}}}}}
+
+	for _, name := range []string{"make", "deploy", "help"} {
+		err := gsh.runControlCommand(name, nil)
+		if err == nil || !strings.Contains(err.Error(), "control API") {
+			t.Errorf("Expected %v to be refused, got %v", name, err)
+		}
+	}
+
+	if err := gsh.runControlCommand("missing", nil); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("Expected an unknown command error, got %v", err)
+	}
+}
+
+func TestLocalOnly(t *testing.T) {
+	handler := localOnly(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+
+	cases := []struct {
+		host     string
+		origin   string
+		expected int
+	}{
+		{"localhost:3812", "", http.StatusOK},
+		{"127.0.0.1:3812", "", http.StatusOK},
+		{"localhost:3812", "http://example.com", http.StatusForbidden},
+		// a DNS rebinding page still sends its own host name
+		{"attacker.example:3812", "", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/status", nil)
+		request.Host = c.host
+		if c.origin != "" {
+			request.Header.Set("Origin", c.origin)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != c.expected {
+			t.Errorf("Expected %v for host %v and origin %q, got %v", c.expected, c.host, c.origin, recorder.Code)
+		}
+	}
+}
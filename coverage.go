@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// coverageCommand merges the coverage data written by services built with the cover variant and summarizes it.
+type coverageCommand struct {
+	gsh *gadgetShell
+}
+
+func (command coverageCommand) info() commandInfo {
+	return commandInfo{
+		name:     "coverage",
+		synopsis: "Summarizes the coverage of services built with the cover variant",
+		args:     "[reset] [service...]",
+		details: "Merges the coverage data of every run since the last reset with go tool covdata, prints the coverage " +
+			"of each package and writes a profile for go tool cover. A run writes its data when the app exits, after " +
+			"stop or a rebuild: apps that don't handle the interrupt gadget sends write none. reset removes the data.",
+		minArgs: 0,
+		maxArgs: -1,
+	}
+}
+
+func (command coverageCommand) execute(input lineReader, args []string) {
+	reset := len(args) > 0 && args[0] == "reset"
+	if reset {
+		args = args[1:]
+	}
+
+	for _, builder := range command.gsh.selectBuilders(args) {
+		dir, err := builder.coverageDir()
+		if err != nil {
+			cmd.PrintfDanger(err.Error())
+
+			return
+		}
+
+		if reset {
+			err = os.RemoveAll(dir)
+			if err != nil {
+				cmd.PrintfDanger(err.Error())
+			} else {
+				cmd.PrintfSuccess("Removed the coverage data of %v", builder.name())
+			}
+
+			continue
+		}
+
+		builder.summarizeCoverage(dir)
+	}
+}
+
+// coverageDir is where a service built with the cover variant writes its coverage data,
+// ~/.clanko-gadget-cli/coverage/<project>/<service>. Projects are told apart by a hash of their path, like runtime
+// files.
+func (b *builder) coverageDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(b.config.Path))
+
+	return filepath.Join(home, gadgetCliConfigDir, "coverage", hex.EncodeToString(sum[:])[:16], b.name()), nil
+}
+
+// prepareCoverageDir creates the coverage directory for a run. Without one, the app runs without collecting coverage.
+func (b *builder) prepareCoverageDir() string {
+	dir, err := b.coverageDir()
+	if err == nil {
+		err = os.MkdirAll(dir, os.ModePerm)
+	}
+
+	if err != nil {
+		cmd.PrintfWarning("Not collecting coverage of %v: %v", b.name(), err)
+
+		return ""
+	}
+
+	return dir
+}
+
+func (b *builder) summarizeCoverage(dir string) {
+	if !hasCoverageData(dir) {
+		if b.config.Variant != "cover" {
+			cmd.PrintfWarning("No coverage data for %v, build it with the cover variant: variant cover %v", b.name(), b.name())
+		} else {
+			cmd.PrintfWarning("No coverage data for %v yet, it's written when the app exits", b.name())
+		}
+
+		return
+	}
+
+	if b.config.Variant == "cover" && b.isRunning() {
+		cmd.PrintfInfo("%v is running, its current run isn't included until it stops", b.name())
+	}
+
+	merged := dir + ".merged"
+	profile := dir + ".coverprofile"
+
+	err := os.RemoveAll(merged)
+	if err == nil {
+		err = os.MkdirAll(merged, os.ModePerm)
+	}
+
+	if err != nil {
+		cmd.PrintfDanger(err.Error())
+
+		return
+	}
+
+	steps := [][]string{
+		{"tool", "covdata", "merge", "-i=" + dir, "-o=" + merged},
+		{"tool", "covdata", "textfmt", "-i=" + merged, "-o=" + profile},
+		{"tool", "covdata", "percent", "-i=" + merged},
+	}
+
+	var output []byte
+	for _, step := range steps {
+		output, err = goCommand(b.config.Path, step...)
+		if err != nil {
+			cmd.PrintfDanger("go %v: %v", strings.Join(step[:3], " "), strings.TrimSpace(string(output)))
+
+			return
+		}
+	}
+
+	cmd.PrintfInfo("Coverage of %v:", b.name())
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		cmd.Write("  " + strings.Join(strings.Fields(line), " ") + "\n")
+	}
+
+	total, err := coverageTotal(profile)
+	if err != nil {
+		cmd.PrintfDanger(err.Error())
+
+		return
+	}
+
+	cmd.PrintfSuccess("Total: %.1f%% of statements", total)
+	cmd.PrintfInfo("Profile at %v, open it with go tool cover -html=%v", profile, profile)
+}
+
+// hasCoverageData reports whether dir has counters written by a run.
+func hasCoverageData(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "covcounters.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// coverageTotal is the percentage of statements covered in a profile written by go tool covdata textfmt.
+func coverageTotal(profile string) (float64, error) {
+	file, err := os.Open(profile)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	// blocks are listed once per run that reached them
+	covered := make(map[string]bool)
+	statements := make(map[string]int)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// file.go:12.34,14.2 3 1
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "mode:") {
+			continue
+		}
+
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverage profile %v: %v", profile, scanner.Text())
+		}
+
+		hits, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverage profile %v: %v", profile, scanner.Text())
+		}
+
+		statements[fields[0]] = count
+		covered[fields[0]] = covered[fields[0]] || hits > 0
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	total, hit := 0, 0
+	for block, count := range statements {
+		total += count
+
+		if covered[block] {
+			hit += count
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+
+	return float64(hit) * 100 / float64(total), nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverageTotal(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "app.coverprofile")
+
+	content := "mode: set\n" +
+		"example.com/app/main.go:5.13,7.2 2 1\n" +
+		"example.com/app/main.go:9.13,12.2 3 0\n" +
+		// a block reached in another run
+		"example.com/app/main.go:9.13,12.2 3 1\n" +
+		"example.com/app/main.go:14.13,16.2 5 0\n"
+
+	err := os.WriteFile(profile, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := coverageTotal(profile)
+	if err != nil || math.Abs(total-50) > 0.01 {
+		t.Errorf("Expected 50%% of statements covered, got %v, %v", total, err)
+	}
+}
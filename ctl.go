@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// runCtl sends a command to the session running for the project and prints its answer, for editor tasks and
+// Makefiles. It returns the exit code.
+//
+//	gadget ctl status [--json]
+//	gadget ctl logs [-f] [-n N] [source]
+//	gadget ctl events
+//	gadget ctl <command> [args...] [--json]
+func runCtl(conf config.Config, args []string) int {
+	if len(args) == 0 {
+		cmd.PrintfWarning("Usage: gadget ctl <command> [args...]")
+
+		return 2
+	}
+
+	session, err := findSession(conf)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return 1
+	}
+
+	conn, err := net.Dial("unix", session.Socket)
+	if err != nil {
+		cmd.PrintfDanger("The gadget session for %v isn't running: %v", conf.Path, err)
+
+		return 1
+	}
+
+	defer conn.Close()
+
+	switch args[0] {
+	case "logs":
+		setLineFormat(conf.Output, conf.LogFormat)
+
+		return ctlLogs(conn, args[1:])
+
+	case "events":
+		err = json.NewEncoder(conn).Encode(controlRequest{Command: "events"})
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+
+			return 1
+		}
+
+		_, _ = io.Copy(os.Stdout, conn)
+
+		return 0
+	}
+
+	jsonOutput := false
+	request := controlRequest{Command: args[0], Args: make([]string, 0)}
+	for _, arg := range args[1:] {
+		if arg == "--json" || arg == "-json" {
+			jsonOutput = true
+		} else {
+			request.Args = append(request.Args, arg)
+		}
+	}
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return 1
+	}
+
+	raw, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		cmd.PrintfDanger("No answer from the gadget session: %v", err)
+
+		return 1
+	}
+
+	var response controlResponse
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		cmd.PrintfDanger("Invalid answer from the gadget session: %v", err)
+
+		return 1
+	}
+
+	if jsonOutput {
+		_, _ = os.Stdout.Write(raw)
+	} else if response.Status != nil {
+		fmt.Print(formatStatus(*response.Status))
+	}
+
+	if !response.OK {
+		if !jsonOutput {
+			cmd.PrintfDanger("%v", response.Error)
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// ctlLogs prints the lines kept by the session, and with -f the lines printed after them until it's interrupted.
+func ctlLogs(conn net.Conn, args []string) int {
+	flags := flag.NewFlagSet("gadget ctl logs", flag.ContinueOnError)
+	follow := flags.Bool("f", false, "Keep printing lines as they're printed")
+	limit := flags.Int("n", defaultLogsLimit, "Number of kept lines to print")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	request := controlRequest{Command: "logs", Args: flags.Args(), Limit: *limit, Follow: *follow}
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return 1
+	}
+
+	// lines are up to 1MiB, more once escaped in JSON
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8<<20)
+
+	for scanner.Scan() {
+		var line logLine
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+
+		fmt.Print(formatLogEntry(logEntry{
+			source:    line.Source,
+			color:     cmd.SourceColor(line.Source),
+			isStderr:  line.Stderr,
+			line:      line.Line,
+			printedAt: line.Time,
+		}))
+	}
+
+	return 0
+}
+
+// findSession reads the runtime file of the session running for the project.
+func findSession(conf config.Config) (sessionRuntime, error) {
+	var session sessionRuntime
+
+	path, err := sessionRuntimePath(conf)
+	if err != nil {
+		return session, err
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return session, fmt.Errorf("no gadget session is running for %v", conf.Path)
+	} else if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(content, &session)
+	if err != nil {
+		return session, fmt.Errorf("invalid session file %v: %v", path, err)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// event is something that happened in the session, streamed to control API clients.
+type event struct {
+	// build, build_failed, start, ready, exit, crash, stop, config or command_failed
+	Type    string    `json:"type"`
+	Source  string    `json:"source"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+}
+
+// eventBus hands events to every subscriber. Subscribers that fall behind miss events rather than holding up the
+// session.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan event]bool
+}
+
+var events = &eventBus{
+	subscribers: make(map[chan event]bool),
+}
+
+func (b *eventBus) publish(eventType string, source string, message string) {
+	published := event{
+		Type:    eventType,
+		Source:  source,
+		Time:    time.Now(),
+		Message: message,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- published:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving events, and a function ending the subscription.
+func (b *eventBus) subscribe() (chan event, func()) {
+	subscriber := make(chan event, 64)
+
+	b.mu.Lock()
+	b.subscribers[subscriber] = true
+	b.mu.Unlock()
+
+	return subscriber, func() {
+		b.mu.Lock()
+		delete(b.subscribers, subscriber)
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// projectInfo is what make gadget-config learns about a project by inspecting it.
+type projectInfo struct {
+	dir    string
+	module string
+	mains  []mainPackage
+	// directories outside the project with code it builds, and why they're included
+	includeDirs []detectedDir
+	// directories in the project that aren't code, and why they're excluded
+	excludeDirs []detectedDir
+}
+
+// mainPackage is a main package of the project.
+type mainPackage struct {
+	// relative to the project, like ./cmd/api, or . for the project's directory
+	pkg string
+	// flag the app reads its address from, with its default turned into an address, empty when none was found
+	addressFlag string
+	address     string
+}
+
+type detectedDir struct {
+	path   string
+	reason string
+}
+
+// name is the service name of the package, after its directory.
+func (app mainPackage) name(project projectInfo) string {
+	if app.pkg == "." {
+		return filepath.Base(project.dir)
+	}
+
+	return path.Base(app.pkg)
+}
+
+// helper packages, examples and tools aren't run with the app
+var skippedMainDirs = map[string]bool{"example": true, "examples": true, "tools": true, "hack": true, "scripts": true}
+
+// folders of assets, builds and dependencies, excluded when they have no Go code
+var assetDirs = map[string]bool{
+	"node_modules": true, "static": true, "public": true, "assets": true, "dist": true, "build": true,
+	"coverage": true, "tmp": true,
+}
+
+// addressFlagPattern matches flags an app takes its listen address from, like flag.String("addr", ":8080", ...).
+var addressFlagPattern = regexp.MustCompile(`flag\.String(?:Var)?\(\s*(?:&[\w.]+\s*,\s*)?"(addr|address|listen|listen-addr|http|http-addr|bind)"\s*,\s*"([^"]*)"`)
+
+// inspectProject finds the main packages of the module in dir, the code it uses outside of dir and the folders that
+// shouldn't trigger rebuilds.
+func inspectProject(dir string) projectInfo {
+	project := projectInfo{dir: dir}
+
+	output, err := goCommand(dir, "list", "-f", "{{.Name}}\t{{.ImportPath}}\t{{.Dir}}\t{{.Module}}", "./...")
+	if err != nil {
+		cmd.PrintfWarning("Couldn't list the packages of %v: %v", dir, strings.TrimSpace(string(output)))
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 4 || fields[0] != "main" {
+			continue
+		}
+
+		project.module = fields[3]
+
+		relative, err := filepath.Rel(dir, fields[2])
+		if err != nil {
+			continue
+		}
+
+		app := mainPackage{pkg: "."}
+		if relative != "." {
+			app.pkg = "./" + filepath.ToSlash(relative)
+		}
+
+		app.addressFlag, app.address = findAddressFlag(fields[2])
+
+		project.mains = append(project.mains, app)
+	}
+
+	project.includeDirs = findIncludeDirs(dir)
+	project.excludeDirs = findExcludeDirs(dir)
+
+	return project
+}
+
+func goCommand(dir string, args ...string) ([]byte, error) {
+	command := exec.Command("go", args...)
+	command.Dir = dir
+
+	return command.CombinedOutput()
+}
+
+// findAddressFlag scans the Go files of a package for a flag holding the listen address.
+func findAddressFlag(dir string) (string, string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", ""
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		match := addressFlagPattern.FindSubmatch(content)
+		if match != nil {
+			return string(match[1]), localAddress(string(match[2]))
+		}
+	}
+
+	return "", ""
+}
+
+// localAddress turns a listen address like :8080 or 0.0.0.0:8080 into one to reach it on, localhost:8080.
+func localAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port == "" {
+		return ""
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// findIncludeDirs finds modules of the go.work and local replace targets outside of dir, their changes affect the
+// build too.
+func findIncludeDirs(dir string) []detectedDir {
+	dirs := make([]detectedDir, 0)
+
+	add := func(base string, target string, reason string) {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(base, target)
+		}
+
+		relative, err := filepath.Rel(dir, target)
+		if err != nil || !strings.HasPrefix(relative, "..") {
+			return
+		}
+
+		for _, existing := range dirs {
+			if existing.path == relative {
+				return
+			}
+		}
+
+		dirs = append(dirs, detectedDir{path: relative, reason: reason})
+	}
+
+	if work, err := goCommand(dir, "env", "GOWORK"); err == nil {
+		workFile := strings.TrimSpace(string(work))
+		if workFile != "" && workFile != "off" {
+			var workJSON struct {
+				Use []struct{ DiskPath string }
+			}
+
+			output, err := goCommand(dir, "work", "edit", "-json", workFile)
+			if err == nil && json.Unmarshal(output, &workJSON) == nil {
+				for _, use := range workJSON.Use {
+					add(filepath.Dir(workFile), use.DiskPath, "a module of "+filepath.Base(workFile))
+				}
+			}
+		}
+	}
+
+	var modJSON struct {
+		Replace []struct {
+			Old struct{ Path string }
+			New struct{ Path string }
+		}
+	}
+
+	output, err := goCommand(dir, "mod", "edit", "-json")
+	if err == nil && json.Unmarshal(output, &modJSON) == nil {
+		for _, replace := range modJSON.Replace {
+			// local replacements are paths, module replacements aren't
+			if strings.HasPrefix(replace.New.Path, ".") || filepath.IsAbs(replace.New.Path) {
+				add(dir, replace.New.Path, "replaces "+replace.Old.Path+" in go.mod")
+			}
+		}
+	}
+
+	return dirs
+}
+
+// findExcludeDirs finds folders of assets, builds and node_modules without Go code, a few levels deep.
+func findExcludeDirs(dir string) []detectedDir {
+	dirs := make([]detectedDir, 0)
+
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() || path == dir {
+			return nil
+		}
+
+		relative, _ := filepath.Rel(dir, path)
+
+		// hidden directories are never watched
+		if strings.HasPrefix(entry.Name(), ".") || strings.Count(relative, string(filepath.Separator)) >= 3 {
+			return filepath.SkipDir
+		}
+
+		if !assetDirs[entry.Name()] {
+			return nil
+		}
+
+		if entry.Name() == "node_modules" {
+			dirs = append(dirs, detectedDir{path: relative, reason: "npm dependencies"})
+		} else if !hasGoFiles(path) {
+			dirs = append(dirs, detectedDir{path: relative, reason: "no Go code"})
+		} else {
+			return nil
+		}
+
+		return filepath.SkipDir
+	})
+
+	return dirs
+}
+
+func hasGoFiles(dir string) bool {
+	found := false
+
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".go") {
+			found = true
+
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// chooseMains picks the main packages to run, skipping examples and tools. Interactively, it asks.
+func chooseMains(project projectInfo, input lineReader) []mainPackage {
+	chosen := make([]mainPackage, 0)
+	for _, app := range project.mains {
+		skipped := false
+		for _, part := range strings.Split(app.pkg, "/") {
+			skipped = skipped || skippedMainDirs[part]
+		}
+
+		if input == nil {
+			if !skipped {
+				chosen = append(chosen, app)
+			}
+
+			continue
+		}
+
+		answer := "Y/n"
+		if skipped {
+			answer = "y/N"
+		}
+
+		description := app.pkg
+		if app.addressFlag != "" {
+			description += fmt.Sprintf(", address flag -%v %v", app.addressFlag, app.address)
+		}
+
+		if ask(input, fmt.Sprintf("Run %v (%v)? [%v] ", app.name(project), description, answer), !skipped) {
+			for app.address == "" {
+				address := readAnswer(input, fmt.Sprintf("Address of %v, like localhost:8080, empty for any free port: ", app.name(project)))
+				if _, _, err := net.SplitHostPort(address); address == "" || err == nil {
+					app.address = address
+
+					break
+				}
+
+				cmd.PrintfWarning("%v isn't an address, use host:port", address)
+			}
+
+			chosen = append(chosen, app)
+		}
+	}
+
+	return chosen
+}
+
+// chooseDirs keeps the detected directories. Interactively, it asks for each.
+func chooseDirs(dirs []detectedDir, key string, input lineReader) []detectedDir {
+	if input == nil {
+		return dirs
+	}
+
+	chosen := make([]detectedDir, 0)
+	for _, dir := range dirs {
+		if ask(input, fmt.Sprintf("Add %v to %v (%v)? [Y/n] ", dir.path, key, dir.reason), true) {
+			chosen = append(chosen, dir)
+		}
+	}
+
+	return chosen
+}
+
+func ask(input lineReader, question string, defaultYes bool) bool {
+	switch strings.ToLower(readAnswer(input, question)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+
+	return defaultYes
+}
+
+func readAnswer(input lineReader, question string) string {
+	answer, err := input.readLine(cmd.FormatInfo(question))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(answer)
+}
+
+// generateConfig writes a gadget.toml for the project. Without input, it uses what it detected, otherwise it asks
+// about each finding.
+func generateConfig(project projectInfo, input lineReader) string {
+	mains := chooseMains(project, input)
+	includeDirs := chooseDirs(project.includeDirs, "include_dirs", input)
+	excludeDirs := chooseDirs(project.excludeDirs, "exclude_dirs", input)
+
+	var buffer bytes.Buffer
+	out := bufio.NewWriter(&buffer)
+
+	fmt.Fprintf(out, "# Generated by make gadget-config for %v.\n", describeModule(project))
+	fmt.Fprintf(out, "# Relative paths are relative to this file. Check it with gadget config check, every setting is described\n")
+	fmt.Fprintf(out, "#   in the sample written by make gadget-config --sample.\n\n")
+
+	fmt.Fprintf(out, "app_name = %q\n", filepath.Base(project.dir))
+
+	// one main package in the project's directory is the app, others are services
+	if len(mains) == 1 && mains[0].pkg == "." {
+		if mains[0].address != "" {
+			fmt.Fprintf(out, "\n# From the -%v flag of the app.\n", mains[0].addressFlag)
+			fmt.Fprintf(out, "app_address = %q\n", mains[0].address)
+			fmt.Fprintf(out, "address_flag = %q\n", mains[0].addressFlag)
+		} else {
+			fmt.Fprintf(out, "\n# The app gets its address as its first argument, set one or gadget finds a free port.\n")
+			fmt.Fprintf(out, "# app_address = \"localhost:8080\"\n")
+		}
+	}
+
+	writeDirs(out, "include_dirs", "Code outside the project the build uses, changes to it rebuild too.", includeDirs)
+	writeDirs(out, "exclude_dirs", "Folders without Go code, changes to them don't rebuild.", excludeDirs)
+
+	if len(mains) > 1 || len(mains) == 1 && mains[0].pkg != "." {
+		fmt.Fprintf(out, "\n# The main packages of %v, each built and run as a service.\n", describeModule(project))
+
+		for _, app := range mains {
+			fmt.Fprintf(out, "\n[[services]]\n")
+			fmt.Fprintf(out, "name = %q\n", app.name(project))
+			fmt.Fprintf(out, "build_package = %q\n", app.pkg)
+
+			if app.address != "" {
+				fmt.Fprintf(out, "address = %q\n", app.address)
+			}
+
+			if app.addressFlag != "" {
+				fmt.Fprintf(out, "address_flag = %q\n", app.addressFlag)
+			}
+		}
+	}
+
+	// packages left out are there to uncomment
+	for _, app := range project.mains {
+		if containsMain(mains, app) {
+			continue
+		}
+
+		fmt.Fprintf(out, "\n# [[services]]\n")
+		fmt.Fprintf(out, "# name = %q\n", app.name(project))
+		fmt.Fprintf(out, "# build_package = %q\n", app.pkg)
+	}
+
+	if len(project.mains) == 0 {
+		fmt.Fprintf(out, "\n# No main package found. Set app_path or add [[services]] with their build_package.\n")
+	}
+
+	_ = out.Flush()
+
+	return buffer.String()
+}
+
+func writeDirs(out *bufio.Writer, key string, comment string, dirs []detectedDir) {
+	if len(dirs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(out, "\n# %v\n", comment)
+	fmt.Fprintf(out, "%v = [\n", key)
+
+	for _, dir := range dirs {
+		fmt.Fprintf(out, "    %v, # %v\n", strconv.Quote(filepath.ToSlash(dir.path)), dir.reason)
+	}
+
+	fmt.Fprintf(out, "]\n")
+}
+
+func containsMain(mains []mainPackage, app mainPackage) bool {
+	for _, chosen := range mains {
+		if chosen.pkg == app.pkg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func describeModule(project projectInfo) string {
+	if project.module != "" {
+		return project.module
+	}
+
+	return filepath.Base(project.dir)
+}
+
+// makeGadgetConfig writes gadget.toml in dir. mode is --auto to use what was detected, --sample for the sample
+// with every setting, or empty to ask.
+func makeGadgetConfig(dir string, mode string, input lineReader) {
+	configPath := filepath.Join(dir, config.FileName)
+
+	_, err := os.Stat(configPath)
+	if err == nil {
+		cmd.PrintfInfo("It looks like there's already a gadget.toml here. Remove or rename it before generating another")
+
+		return
+	}
+
+	var content string
+
+	switch mode {
+	case "--sample":
+		content = config.GetSampleConfigFileContent()
+	case "--auto":
+		cmd.PrintfInfo("Inspecting %v", dir)
+		content = generateConfig(inspectProject(dir), nil)
+	case "":
+		cmd.PrintfInfo("Inspecting %v", dir)
+		content = generateConfig(inspectProject(dir), input)
+	default:
+		cmd.PrintfWarning("Usage: make gadget-config [--auto | --sample]")
+
+		return
+	}
+
+	err = os.WriteFile(configPath, []byte(content), 0644)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return
+	}
+
+	cmd.PrintfSuccess("Wrote %v", configPath)
+}
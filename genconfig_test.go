@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	// keep the user's ~/.clanko-gadget-cli/config.toml out of the test
+	t.Setenv("HOME", t.TempDir())
+
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "shop")
+
+	files := map[string]string{
+		"shop/go.mod": "module example.com/shop\n\ngo 1.21\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => ../lib\n",
+		"shop/cmd/api/main.go": "package main\n\nimport \"flag\"\n\nvar addr = flag.String(\"addr\", \":9000\", \"listen address\")\n\n" +
+			"func main() { flag.Parse() }\n",
+		"shop/cmd/worker/main.go":     "package main\n\nfunc main() {}\n",
+		"shop/examples/demo/main.go":  "package main\n\nfunc main() {}\n",
+		"shop/web/static/app.css":     "body {}\n",
+		"shop/web/node_modules/x.js":  "\n",
+		"shop/internal/build/make.go": "package build\n",
+		"lib/go.mod":                  "module example.com/lib\n\ngo 1.21\n",
+	}
+
+	writeTestFiles(t, root, files)
+
+	generated := generateConfig(inspectProject(dir), nil)
+
+	expected := []string{
+		"name = \"api\"\nbuild_package = \"./cmd/api\"\naddress = \"localhost:9000\"\naddress_flag = \"addr\"",
+		"name = \"worker\"\nbuild_package = \"./cmd/worker\"",
+		"# name = \"demo\"",
+		"\"../lib\", # replaces example.com/lib in go.mod",
+		"\"web/node_modules\", # npm dependencies",
+		"\"web/static\", # no Go code",
+	}
+
+	for _, part := range expected {
+		if !strings.Contains(generated, part) {
+			t.Errorf("Expected the config to contain %q, got\n%v", part, generated)
+		}
+	}
+
+	if strings.Contains(generated, "internal/build") {
+		t.Errorf("Expected a build folder with Go code to be watched, got\n%v", generated)
+	}
+
+	configPath := filepath.Join(dir, config.FileName)
+
+	err := os.WriteFile(configPath, []byte(generated), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, problems := config.Load(configPath, config.Flags{})
+	if problems.HasErrors() {
+		t.Errorf("Expected the generated config to load, got %v", problems)
+	}
+}
+
+// writeTestFiles writes files, named by their path relative to root, creating the directories they're in.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err == nil {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
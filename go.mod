@@ -5,7 +5,8 @@ go 1.23.0
 require (
 	github.com/clanko/scaffold v1.0.1
 	github.com/fsnotify/fsnotify v1.7.0
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904
 	github.com/pelletier/go-toml/v2 v2.2.3
+	golang.org/x/sys v0.4.0
+	golang.org/x/term v0.4.0
 )
-
-require golang.org/x/sys v0.4.0 // indirect
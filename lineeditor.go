@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bufio"
+	"github.com/clanko/gadget/cmd"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// number of lines kept in the history file
+const historyLines = 1000
+
+// escapeTimeout is how long readKey waits for the rest of an escape sequence before taking escape as a key of its own,
+// like readline's keyseq-timeout.
+const escapeTimeout = 100 * time.Millisecond
+
+// lineReader reads a line of input after printing a prompt.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// lineEditor reads lines with editing, history and completion when stdin is a terminal, and plain lines otherwise.
+type lineEditor struct {
+	input      *bufio.Reader
+	fd         int
+	isTerminal bool
+	// returns the start of the word being completed and the candidates for it
+	complete    func(line string) (int, []string)
+	history     []string
+	historyPath string
+
+	// state of the line being edited, guarded by mu since output from other goroutines redraws it
+	mu     sync.Mutex
+	prompt string
+	buffer []rune
+	cursor int
+	// the history entry shown, len(history) for the line being typed, which is kept in pending while browsing
+	historyIndex int
+	pending      string
+	// reverse history search
+	searching   bool
+	searchQuery []rune
+	searchMatch int
+}
+
+func newLineEditor(historyPath string, complete func(line string) (int, []string)) *lineEditor {
+	fd := int(os.Stdin.Fd())
+
+	editor := &lineEditor{
+		input:       bufio.NewReader(os.Stdin),
+		fd:          fd,
+		isTerminal:  term.IsTerminal(fd) && term.IsTerminal(int(os.Stderr.Fd())),
+		complete:    complete,
+		historyPath: historyPath,
+	}
+
+	editor.loadHistory()
+
+	return editor
+}
+
+// readLine reads a line. It returns io.EOF once input ends, or when ^D is entered on an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.isTerminal {
+		return e.readPlainLine(prompt)
+	}
+
+	err := terminal.makeRaw(e.fd)
+	if err != nil {
+		return e.readPlainLine(prompt)
+	}
+
+	defer terminal.restore(e.fd)
+
+	e.mu.Lock()
+	e.prompt = prompt
+	e.buffer = e.buffer[:0]
+	e.cursor = 0
+	e.searching = false
+	e.historyIndex = len(e.history)
+	e.pending = ""
+	e.mu.Unlock()
+
+	terminal.setPromptLine(e.promptLine)
+	terminal.showPrompt()
+
+	defer terminal.setPromptLine(promptText)
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			terminal.promptAnswered()
+
+			return "", err
+		}
+
+		e.mu.Lock()
+		action := e.handleKey(key)
+		line := string(e.buffer)
+		e.mu.Unlock()
+
+		switch action {
+		case keyAccept:
+			e.redraw()
+			terminal.promptAnswered()
+			terminal.print("\n")
+
+			return line, nil
+
+		case keyInterrupt, keyExit:
+			terminal.promptAnswered()
+			terminal.print("^C\n")
+
+			if action == keyExit {
+				terminal.restore(e.fd)
+				_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+			}
+
+			terminal.showPrompt()
+
+			continue
+
+		case keyEOF:
+			terminal.promptAnswered()
+			terminal.print("\n")
+
+			return "", io.EOF
+
+		case keyClearScreen:
+			terminal.print("\x1b[H\x1b[2J")
+
+		case keyComplete:
+			candidates := e.completeWord()
+			if len(candidates) > 0 {
+				// nothing more in common, list the candidates under the line
+				terminal.promptAnswered()
+				terminal.print("\n" + strings.Join(candidates, "  ") + "\n")
+				terminal.showPrompt()
+
+				continue
+			}
+		}
+
+		e.redraw()
+	}
+}
+
+// keyAction is what readLine does after a key press changed the line.
+type keyAction int
+
+const (
+	// redraw the line
+	keyEdit keyAction = iota
+	keyAccept
+	// ^C cleared the line
+	keyInterrupt
+	// ^C on an empty line exits gadget
+	keyExit
+	keyEOF
+	keyClearScreen
+	keyComplete
+)
+
+// handleKey applies a key press to the line being edited. Must hold e.mu.
+func (e *lineEditor) handleKey(key string) keyAction {
+	if e.searching && !e.searchKey(key) {
+		return keyEdit
+	}
+
+	switch key {
+	case "\r", "\n":
+		return keyAccept
+
+	case "\x03": // ^C clears the line, or exits gadget on an empty line
+		if len(e.buffer) == 0 {
+			return keyExit
+		}
+
+		e.buffer = e.buffer[:0]
+		e.cursor = 0
+
+		return keyInterrupt
+
+	case "\x04": // ^D deletes under the cursor, or ends input on an empty line
+		if len(e.buffer) == 0 {
+			return keyEOF
+		}
+
+		e.deleteRunes(e.cursor, e.cursor+1)
+
+	case "\x7f", "\x08": // backspace
+		e.deleteRunes(e.cursor-1, e.cursor)
+
+	case "\x1b[3~": // delete
+		e.deleteRunes(e.cursor, e.cursor+1)
+
+	case "\x1b[D", "\x02": // left, ^B
+		e.cursor = max(e.cursor-1, 0)
+
+	case "\x1b[C", "\x06": // right, ^F
+		e.cursor = min(e.cursor+1, len(e.buffer))
+
+	case "\x1b[H", "\x1bOH", "\x1b[1~", "\x01": // home, ^A
+		e.cursor = 0
+
+	case "\x1b[F", "\x1bOF", "\x1b[4~", "\x05": // end, ^E
+		e.cursor = len(e.buffer)
+
+	case "\x1b[1;5D", "\x1bb": // ctrl+left, alt+b
+		e.cursor = e.previousWordStart()
+
+	case "\x1b[1;5C", "\x1bf": // ctrl+right, alt+f
+		e.cursor = e.nextWordEnd()
+
+	case "\x0b": // ^K
+		e.buffer = e.buffer[:e.cursor]
+
+	case "\x15": // ^U
+		e.deleteRunes(0, e.cursor)
+
+	case "\x17": // ^W
+		e.deleteRunes(e.previousWordStart(), e.cursor)
+
+	case "\x0c": // ^L
+		return keyClearScreen
+
+	case "\x1b[A", "\x10": // up, ^P
+		if e.historyIndex > 0 {
+			if e.historyIndex == len(e.history) {
+				e.pending = string(e.buffer)
+			}
+
+			e.historyIndex--
+			e.setBuffer(e.history[e.historyIndex])
+		}
+
+	case "\x1b[B", "\x0e": // down, ^N
+		if e.historyIndex < len(e.history) {
+			e.historyIndex++
+			if e.historyIndex == len(e.history) {
+				e.setBuffer(e.pending)
+			} else {
+				e.setBuffer(e.history[e.historyIndex])
+			}
+		}
+
+	case "\x12": // ^R
+		e.searching = true
+		e.searchQuery = e.searchQuery[:0]
+		e.searchMatch = len(e.history)
+
+	case "\t":
+		return keyComplete
+
+	default:
+		runes := []rune(key)
+		if len(runes) == 1 && unicode.IsPrint(runes[0]) {
+			e.insertRunes(runes)
+		}
+	}
+
+	return keyEdit
+}
+
+// readPlainLine reads a line without editing, when stdin isn't a terminal.
+func (e *lineEditor) readPlainLine(prompt string) (string, error) {
+	terminal.setPromptLine(func() string {
+		return prompt
+	})
+	terminal.showPrompt()
+
+	defer terminal.setPromptLine(promptText)
+
+	line, err := e.input.ReadString('\n')
+	terminal.promptAnswered()
+
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readKey reads a key press, either a single rune or a whole escape sequence.
+func (e *lineEditor) readKey() (string, error) {
+	r, _, err := e.input.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	if r != '\x1b' {
+		return string(r), nil
+	}
+
+	// terminals send a sequence at once, escape on its own is a key press
+	if !e.inputWithin(escapeTimeout) {
+		return string(r), nil
+	}
+
+	next, _, err := e.input.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	if next != '[' && next != 'O' {
+		// alt+key
+		return string([]rune{r, next}), nil
+	}
+
+	sequence := []rune{r, next}
+	for {
+		final, _, err := e.input.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		sequence = append(sequence, final)
+
+		// parameters and intermediates are below @, the final byte ends the sequence
+		if final >= '@' && final <= '~' {
+			return string(sequence), nil
+		}
+	}
+}
+
+// inputWithin reports whether more input arrives within timeout. Only a terminal is waited for, other input has all it
+// will have buffered.
+func (e *lineEditor) inputWithin(timeout time.Duration) bool {
+	if e.input.Buffered() > 0 {
+		return true
+	}
+
+	if !e.isTerminal {
+		return false
+	}
+
+	for {
+		ready, err := unix.Poll([]unix.PollFd{{Fd: int32(e.fd), Events: unix.POLLIN}}, int(timeout.Milliseconds()))
+		if err == unix.EINTR {
+			continue
+		}
+
+		return err == nil && ready > 0
+	}
+}
+
+// searchKey handles a key while searching the history. It returns true when the search ended and the key should be
+// handled as usual.
+func (e *lineEditor) searchKey(key string) bool {
+	switch key {
+	case "\x12": // ^R finds the next older match
+		e.findHistory(e.searchMatch - 1)
+
+		return false
+
+	case "\x7f", "\x08":
+		if len(e.searchQuery) > 0 {
+			e.searchQuery = e.searchQuery[:len(e.searchQuery)-1]
+			e.findHistory(len(e.history) - 1)
+		}
+
+		return false
+
+	case "\x07", "\x1b": // ^G and escape cancel the search
+		e.searching = false
+		e.buffer = e.buffer[:0]
+		e.cursor = 0
+
+		return false
+	}
+
+	runes := []rune(key)
+	if len(runes) == 1 && unicode.IsPrint(runes[0]) {
+		e.searchQuery = append(e.searchQuery, runes[0])
+		e.findHistory(min(e.searchMatch, len(e.history)-1))
+
+		return false
+	}
+
+	// any other key accepts the match
+	e.searching = false
+
+	return true
+}
+
+// findHistory finds the newest history entry containing the search query, starting from index.
+func (e *lineEditor) findHistory(index int) {
+	query := string(e.searchQuery)
+	for i := index; i >= 0; i-- {
+		if strings.Contains(e.history[i], query) {
+			e.searchMatch = i
+			e.setBuffer(e.history[i])
+
+			return
+		}
+	}
+}
+
+// completeWord completes the word before the cursor with what the candidates have in common. When they have nothing
+// more in common, it returns them to be listed.
+func (e *lineEditor) completeWord() []string {
+	if e.complete == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	line := string(e.buffer[:e.cursor])
+	e.mu.Unlock()
+
+	start, candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	word := line[start:]
+	prefix := commonPrefix(candidates)
+
+	if len(candidates) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+
+	if len(prefix) > len(word) {
+		e.mu.Lock()
+		e.insertRunes([]rune(prefix[len(word):]))
+		e.mu.Unlock()
+
+		return nil
+	}
+
+	return candidates
+}
+
+// commonPrefix is the longest run of whole runes the values start with.
+func commonPrefix(values []string) string {
+	prefix := []rune(values[0])
+	for _, value := range values[1:] {
+		runes := []rune(value)
+
+		n := 0
+		for n < len(prefix) && n < len(runes) && prefix[n] == runes[n] {
+			n++
+		}
+
+		prefix = prefix[:n]
+	}
+
+	return string(prefix)
+}
+
+// must hold e.mu
+func (e *lineEditor) insertRunes(runes []rune) {
+	buffer := make([]rune, 0, len(e.buffer)+len(runes))
+	buffer = append(buffer, e.buffer[:e.cursor]...)
+	buffer = append(buffer, runes...)
+	buffer = append(buffer, e.buffer[e.cursor:]...)
+
+	e.buffer = buffer
+	e.cursor += len(runes)
+}
+
+// must hold e.mu
+func (e *lineEditor) deleteRunes(from int, to int) {
+	from = max(from, 0)
+	to = min(to, len(e.buffer))
+	if from >= to {
+		return
+	}
+
+	e.buffer = append(e.buffer[:from], e.buffer[to:]...)
+	if e.cursor > to {
+		e.cursor -= to - from
+	} else if e.cursor > from {
+		e.cursor = from
+	}
+}
+
+// must hold e.mu
+func (e *lineEditor) setBuffer(line string) {
+	e.buffer = []rune(line)
+	e.cursor = len(e.buffer)
+}
+
+// must hold e.mu
+func (e *lineEditor) previousWordStart() int {
+	i := e.cursor
+	for i > 0 && unicode.IsSpace(e.buffer[i-1]) {
+		i--
+	}
+
+	for i > 0 && !unicode.IsSpace(e.buffer[i-1]) {
+		i--
+	}
+
+	return i
+}
+
+// must hold e.mu
+func (e *lineEditor) nextWordEnd() int {
+	i := e.cursor
+	for i < len(e.buffer) && unicode.IsSpace(e.buffer[i]) {
+		i++
+	}
+
+	for i < len(e.buffer) && !unicode.IsSpace(e.buffer[i]) {
+		i++
+	}
+
+	return i
+}
+
+func (e *lineEditor) redraw() {
+	terminal.showPrompt()
+}
+
+// promptLine draws the prompt and the line being edited, leaving the terminal's cursor at the editing cursor.
+func (e *lineEditor) promptLine() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prompt := e.prompt
+	if e.searching {
+		prompt = cmd.FormatInfo("(reverse-i-search)`%v': ", string(e.searchQuery))
+	}
+
+	line := prompt + string(e.buffer) + "\x1b[K"
+	if back := len(e.buffer) - e.cursor; back > 0 {
+		line += "\x1b[" + strconv.Itoa(back) + "D"
+	}
+
+	return line
+}
+
+// addHistory keeps a line entered in the shell, in memory and in the history file.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > historyLines {
+		e.history = e.history[len(e.history)-historyLines:]
+	}
+
+	if e.historyPath == "" {
+		return
+	}
+
+	file, err := os.OpenFile(e.historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+
+	_, _ = file.WriteString(line + "\n")
+	_ = file.Close()
+}
+
+// loadHistory reads the history file, trimming it when it grew well past historyLines.
+func (e *lineEditor) loadHistory() {
+	if e.historyPath == "" {
+		return
+	}
+
+	content, err := os.ReadFile(e.historyPath)
+	if err != nil {
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) > historyLines {
+		trim := len(lines) > 2*historyLines
+
+		lines = lines[len(lines)-historyLines:]
+
+		if trim {
+			_ = os.WriteFile(e.historyPath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+		}
+	}
+
+	for _, line := range lines {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+}
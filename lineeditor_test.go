@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestEditor returns an editor reading keys from input, with history.
+func newTestEditor(input string, history ...string) *lineEditor {
+	return &lineEditor{
+		input:        bufio.NewReader(bytes.NewReader([]byte(input))),
+		history:      history,
+		historyIndex: len(history),
+	}
+}
+
+// typeKeys feeds every key of the input to the editor, like readLine does, and returns the last action.
+func typeKeys(t *testing.T, e *lineEditor) keyAction {
+	t.Helper()
+
+	action := keyEdit
+
+	for {
+		key, err := e.readKey()
+		if err == io.EOF {
+			return action
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		action = e.handleKey(key)
+		if action == keyComplete {
+			e.completeWord()
+		}
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	e := newTestEditor("a\x1b[A\x1b[1;5Cé\x1bb\x1b")
+
+	var keys []string
+
+	for {
+		key, err := e.readKey()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	expected := []string{"a", "\x1b[A", "\x1b[1;5C", "é", "\x1bb", "\x1b"}
+	if reflect.DeepEqual(keys, expected) == false {
+		t.Errorf("Expected keys %q, got %q", expected, keys)
+	}
+}
+
+func TestHandleKey(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   string
+		cursor int
+		action keyAction
+	}{
+		{"hello", "hello", 5, keyEdit},
+		{"héllo\x7f\x7f", "hél", 3, keyEdit},
+		{"world\x01hello \x05!", "hello world!", 12, keyEdit},
+		{"abc\x1b[D\x1b[Dx\x1b[C\x1b[3~", "axb", 3, keyEdit},
+		{"go test ./...\x17\x17", "go ", 3, keyEdit},
+		{"go test\x1bb\x0b", "go ", 3, keyEdit},
+		{"go test\x01\x1bf\x15", " test", 0, keyEdit},
+		{"ab\x02\x04", "a", 1, keyEdit},
+		{"abc\x03", "", 0, keyInterrupt},
+		{"\x03", "", 0, keyExit},
+		{"\x04", "", 0, keyEOF},
+		{"abc\r", "abc", 3, keyAccept},
+		{"a\x0c", "a", 1, keyClearScreen},
+		{"a\x1b[1;2P", "a", 1, keyEdit},
+	}
+
+	for _, test := range tests {
+		e := newTestEditor(test.input)
+
+		action := typeKeys(t, e)
+		if action != test.action {
+			t.Errorf("Expected action %v for %q, got %v", test.action, test.input, action)
+		}
+
+		if string(e.buffer) != test.line || e.cursor != test.cursor {
+			t.Errorf("Expected %q to edit %q with the cursor at %v, got %q at %v", test.input, test.line, test.cursor,
+				string(e.buffer), e.cursor)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tests := map[string]string{
+		"typed\x1b[A":                 "status",
+		"typed\x1b[A\x1b[A\x1b[A\x10": "build",
+		"typed\x1b[A\x1b[A\x1b[B":     "status",
+		"typed\x1b[A\x1b[B\x1b[B\x0e": "typed",
+		"\x1b[B":                      "",
+	}
+
+	for input, expected := range tests {
+		e := newTestEditor(input, "build", "run", "status")
+
+		typeKeys(t, e)
+
+		if string(e.buffer) != expected || e.cursor != len(e.buffer) {
+			t.Errorf("Expected %q to show %q with the cursor at its end, got %q at %v", input, expected,
+				string(e.buffer), e.cursor)
+		}
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	tests := []struct {
+		input     string
+		line      string
+		searching bool
+	}{
+		{"\x12ru", "run ./cmd/b", true},
+		{"\x12ru\x12", "run ./cmd/a", true},
+		{"\x12ru\x12\x12", "run ./cmd/a", true},
+		{"\x12rux\x7f", "run ./cmd/b", true},
+		{"\x12run\x1b", "", false},
+		{"\x12run\x07", "", false},
+		{"\x12run\x1b[D", "run ./cmd/", false},
+		{"\x12run\x05!", "run ./cmd/b!", false},
+		{"\x12xyz", "", true},
+	}
+
+	for _, test := range tests {
+		e := newTestEditor(test.input, "run ./cmd/a", "build", "run ./cmd/b", "status")
+
+		typeKeys(t, e)
+
+		if string(e.buffer[:e.cursor]) != test.line || e.searching != test.searching {
+			t.Errorf("Expected %q to find %q, searching %v, got %q, searching %v", test.input, test.line,
+				test.searching, string(e.buffer[:e.cursor]), e.searching)
+		}
+	}
+}
+
+func TestCompleteWord(t *testing.T) {
+	complete := func(line string) (int, []string) {
+		start := strings.LastIndex(line, " ") + 1
+
+		var candidates []string
+
+		for _, value := range []string{"build", "bench", "café", "cafés", "cmd/", "restart"} {
+			if strings.HasPrefix(value, line[start:]) {
+				candidates = append(candidates, value)
+			}
+		}
+
+		return start, candidates
+	}
+
+	tests := []struct {
+		input      string
+		line       string
+		candidates []string
+	}{
+		{"res", "restart ", nil},
+		{"go cm", "go cmd/", nil},
+		{"ca", "café", nil},
+		{"b", "b", []string{"build", "bench"}},
+		{"x", "x", nil},
+	}
+
+	for _, test := range tests {
+		e := newTestEditor(test.input)
+		e.complete = complete
+
+		typeKeys(t, e)
+
+		candidates := e.completeWord()
+		if string(e.buffer) != test.line || e.cursor != len(e.buffer) {
+			t.Errorf("Expected %q to complete to %q, got %q", test.input, test.line, string(e.buffer))
+		}
+
+		if reflect.DeepEqual(candidates, test.candidates) == false {
+			t.Errorf("Expected %q to list %q, got %q", test.input, test.candidates, candidates)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected string
+	}{
+		{[]string{"build", "bench"}, "b"},
+		{[]string{"café", "cafè"}, "caf"},
+		{[]string{"日本", "日本語"}, "日本"},
+		{[]string{"run"}, "run"},
+		{[]string{"a", "b"}, ""},
+	}
+
+	for _, test := range tests {
+		prefix := commonPrefix(test.values)
+		if prefix != test.expected {
+			t.Errorf("Expected the common prefix of %q to be %q, got %q", test.values, test.expected, prefix)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// logEntry is a line printed by the app, the debugger or another process.
+type logEntry struct {
+	source    string
+	color     string
+	isStderr  bool
+	line      string
+	printedAt time.Time
+}
+
+// logBuffer keeps the most recent lines printed by managed processes, so they can be searched and saved after they
+// scrolled away.
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []logEntry
+	// index of the oldest entry once the buffer is full
+	next int
+	// channels receiving entries as they're added
+	followers map[chan logEntry]bool
+}
+
+const defaultScrollback = 10000
+
+var scrollback = newLogBuffer(defaultScrollback)
+
+func newLogBuffer(capacity int) *logBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &logBuffer{
+		entries:   make([]logEntry, 0, capacity),
+		followers: make(map[chan logEntry]bool),
+	}
+}
+
+func (l *logBuffer) add(entry logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for follower := range l.followers {
+		select {
+		case follower <- entry:
+		default:
+		}
+	}
+
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+
+		return
+	}
+
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// all returns the kept entries, oldest first.
+func (l *logBuffer) all() []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.allLocked()
+}
+
+// follow returns the kept entries, and a channel receiving every entry added after them. Entries are dropped when
+// the channel isn't read fast enough. The returned function stops following.
+func (l *logBuffer) follow() ([]logEntry, chan logEntry, func()) {
+	follower := make(chan logEntry, 1024)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.followers[follower] = true
+
+	return l.allLocked(), follower, func() {
+		l.mu.Lock()
+		delete(l.followers, follower)
+		l.mu.Unlock()
+	}
+}
+
+func (l *logBuffer) allLocked() []logEntry {
+	entries := make([]logEntry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	entries = append(entries, l.entries[:l.next]...)
+
+	return entries
+}
+
+// filter returns the kept entries matching keep, oldest first. With a limit above 0 only the last limit entries are
+// returned.
+func (l *logBuffer) filter(keep func(entry logEntry) bool, limit int) []logEntry {
+	return filterEntries(l.all(), keep, limit)
+}
+
+func filterEntries(entries []logEntry, keep func(entry logEntry) bool, limit int) []logEntry {
+	matching := make([]logEntry, 0)
+	for _, entry := range entries {
+		if keep(entry) {
+			matching = append(matching, entry)
+		}
+	}
+
+	if limit > 0 && len(matching) > limit {
+		matching = matching[len(matching)-limit:]
+	}
+
+	return matching
+}
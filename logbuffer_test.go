@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buffer := newLogBuffer(3)
+
+	for i := 1; i <= 5; i++ {
+		buffer.add(logEntry{source: "api", line: strconv.Itoa(i)})
+	}
+
+	entries := buffer.all()
+	if len(entries) != 3 || entries[0].line != "3" || entries[2].line != "5" {
+		t.Errorf("Expected the last 3 lines oldest first, got %v", entries)
+	}
+
+	odd := buffer.filter(func(entry logEntry) bool {
+		line, _ := strconv.Atoi(entry.line)
+
+		return line%2 == 1
+	}, 1)
+
+	if len(odd) != 1 || odd[0].line != "5" {
+		t.Errorf("Expected the last odd line, got %v", odd)
+	}
+}
+
+func TestLogBufferFollow(t *testing.T) {
+	buffer := newLogBuffer(10)
+	buffer.add(logEntry{source: "api", line: "1"})
+
+	kept, follower, stop := buffer.follow()
+	buffer.add(logEntry{source: "api", line: "2"})
+
+	if len(kept) != 1 || kept[0].line != "1" {
+		t.Errorf("Expected the line kept before following, got %v", kept)
+	}
+
+	if entry := <-follower; entry.line != "2" {
+		t.Errorf("Expected the line added after following, got %v", entry)
+	}
+
+	stop()
+	buffer.add(logEntry{source: "api", line: "3"})
+
+	if len(follower) != 0 {
+		t.Errorf("Expected no lines after following stopped")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionLogs writes everything printed in the session to files. It's nil when log files are disabled.
+var sessionLogs *logFiles
+
+// logFiles writes the lines of each source to its own file for the session, rotating files by size.
+type logFiles struct {
+	dir      string
+	session  string
+	maxSize  int64
+	maxFiles int
+	files    map[string]*rotatingFile
+	mu       sync.Mutex
+}
+
+type rotatingFile struct {
+	path string
+	file *os.File
+	size int64
+}
+
+var sourceFileName = strings.NewReplacer("/", "-", ":", "-", " ", "-")
+
+func newLogFiles(conf config.LogFiles) (*logFiles, error) {
+	err := os.MkdirAll(conf.Dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logFiles{
+		dir:      conf.Dir,
+		session:  time.Now().Format("20060102-150405"),
+		maxSize:  int64(max(conf.MaxSize, 1)) * 1024 * 1024,
+		maxFiles: max(conf.MaxFiles, 1),
+		files:    make(map[string]*rotatingFile),
+	}, nil
+}
+
+// write appends text to the source's file, a line at a time, without colors.
+func (l *logFiles) write(source string, text string, printedAt time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text = strings.TrimRight(cmd.StripColors(text), "\r\n")
+	for _, line := range strings.Split(text, "\n") {
+		l.writeLine(source, printedAt.Format("2006-01-02T15:04:05.000")+" "+line+"\n")
+	}
+}
+
+// marker writes a line standing out from the output to the source's file, like the start of a build.
+func (l *logFiles) marker(source string, text string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.writeLine(source, fmt.Sprintf("==== %v %v ====\n", time.Now().Format("2006-01-02T15:04:05.000"), text))
+}
+
+func (l *logFiles) writeLine(source string, line string) {
+	file, ok := l.files[source]
+	if !ok {
+		file = &rotatingFile{
+			path: filepath.Join(l.dir, sourceFileName.Replace(source)+"-"+l.session+".log"),
+		}
+
+		l.files[source] = file
+	}
+
+	if file.file != nil && file.size+int64(len(line)) > l.maxSize {
+		l.rotate(file)
+	}
+
+	if file.file == nil {
+		opened, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+
+		file.file = opened
+		file.size = 0
+	}
+
+	written, _ := file.file.WriteString(line)
+	file.size += int64(written)
+}
+
+// rotate renames file.log to file.log.1, file.log.1 to file.log.2 and so on, removing the oldest beyond maxFiles.
+func (l *logFiles) rotate(file *rotatingFile) {
+	_ = file.file.Close()
+	file.file = nil
+
+	_ = os.Remove(fmt.Sprintf("%v.%v", file.path, l.maxFiles-1))
+
+	for i := l.maxFiles - 2; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%v.%v", file.path, i), fmt.Sprintf("%v.%v", file.path, i+1))
+	}
+
+	if l.maxFiles > 1 {
+		_ = os.Rename(file.path, file.path+".1")
+	} else {
+		_ = os.Remove(file.path)
+	}
+}
+
+func (l *logFiles) close() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, file := range l.files {
+		if file.file != nil {
+			_ = file.file.Close()
+			file.file = nil
+		}
+	}
+}
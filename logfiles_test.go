@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFilesRotate(t *testing.T) {
+	logs, err := newLogFiles(config.LogFiles{Dir: t.TempDir(), MaxSize: 1, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer logs.close()
+
+	line := strings.Repeat("a", 400*1024)
+	for i := 0; i < 10; i++ {
+		logs.write("dlv:api", cmd.FormatDanger(line), time.Now())
+	}
+
+	files, err := filepath.Glob(filepath.Join(logs.dir, "dlv-api-*.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 3 {
+		t.Errorf("Expected 3 files kept, got %v", files)
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), cmd.COLOR_DANGER) {
+		t.Errorf("Expected colors to be stripped")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// number of lines logs prints without -n
+const defaultLogsLimit = 100
+
+// logsCommand prints lines kept in the scrollback.
+//
+//	logs [source] [-n N]
+//	logs grep <regex> [-n N]
+//	logs since <duration> [-n N]
+//	logs save <file>
+type logsCommand struct {
+	gsh *gadgetShell
+}
+
+func (command logsCommand) info() commandInfo {
+	return commandInfo{
+		name:     "logs",
+		synopsis: "Prints output kept from the app, the debugger and processes",
+		args:     "[source | grep | since | save] [-n N]",
+		details: "logs [source] [-n N] prints the last N lines, 100 by default, optionally of one source like api or dlv:api.\n" +
+			"logs grep <regex> prints the lines matching a regular expression.\n" +
+			"logs since <duration> prints the lines printed in the last duration, e.g. 5m.\n" +
+			"logs save <file> saves every kept line to a file as plain text.",
+		minArgs: 0,
+		maxArgs: -1,
+	}
+}
+
+func (command logsCommand) execute(input lineReader, args []string) {
+	args, limit, err := parseLogsLimit(args)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return
+	}
+
+	keep := func(entry logEntry) bool {
+		return true
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "grep":
+			if len(args) < 2 {
+				cmd.PrintfWarning("Usage: logs grep <regex> [-n N]")
+
+				return
+			}
+
+			pattern, err := regexp.Compile(strings.Join(args[1:], " "))
+			if err != nil {
+				cmd.PrintfDanger("Invalid regex: %v", err)
+
+				return
+			}
+
+			keep = func(entry logEntry) bool {
+				return pattern.MatchString(cmd.StripColors(entry.line))
+			}
+
+		case "since":
+			if len(args) != 2 {
+				cmd.PrintfWarning("Usage: logs since <duration> [-n N]")
+
+				return
+			}
+
+			duration, err := time.ParseDuration(args[1])
+			if err != nil {
+				cmd.PrintfDanger("Invalid duration, use values like 30s or 5m: %v", err)
+
+				return
+			}
+
+			since := time.Now().Add(-duration)
+			keep = func(entry logEntry) bool {
+				return entry.printedAt.After(since)
+			}
+
+		case "save":
+			if len(args) != 2 {
+				cmd.PrintfWarning("Usage: logs save <file>")
+
+				return
+			}
+
+			saveLogs(args[1])
+
+			return
+
+		default:
+			source := args[0]
+			keep = func(entry logEntry) bool {
+				return entry.source == source
+			}
+		}
+	}
+
+	for _, entry := range scrollback.filter(keep, limit) {
+		printLogEntry(entry)
+	}
+}
+
+// parseLogsLimit takes -n N out of args. Without -n, the limit is defaultLogsLimit.
+func parseLogsLimit(args []string) ([]string, int, error) {
+	limit := defaultLogsLimit
+	remaining := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-n" {
+			remaining = append(remaining, args[i])
+
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, 0, fmt.Errorf("-n needs a number of lines")
+		}
+
+		parsed, err := strconv.Atoi(args[i+1])
+		if err != nil || parsed < 1 {
+			return nil, 0, fmt.Errorf("invalid number of lines: %v", args[i+1])
+		}
+
+		limit = parsed
+		i++
+	}
+
+	return remaining, limit, nil
+}
+
+// saveLogs writes the whole scrollback to path as plain text, one line per entry with its time and source.
+func saveLogs(path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return
+	}
+
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+		}
+	}(file)
+
+	writer := bufio.NewWriter(file)
+
+	entries := scrollback.all()
+	for _, entry := range entries {
+		line := strings.TrimRight(cmd.StripColors(entry.line), "\r\n")
+
+		_, err = fmt.Fprintf(writer, "%v [%v] %v\n", entry.printedAt.Format("2006-01-02T15:04:05.000Z07:00"), entry.source, line)
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+
+			return
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return
+	}
+
+	cmd.PrintfSuccess("Saved %v lines to %v", len(entries), path)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"github.com/clanko/gadget/cmd"
 	"github.com/clanko/gadget/config"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 )
 
 const (
@@ -14,18 +19,20 @@ const (
 )
 
 var (
+	// the config file loaded for the session, found by resolveConfigFile unless set with -config
+	configFile string
 	help       bool
 	appPath    string
 	binaryName string
 	listenPort int
-	verbose    = 0
+	// the [profile.<name>] of the config in use, set with -profile and the profile command
+	profileName string
+	verbose     = 0
 )
 
 // Gadget CLI
 func main() {
-	goVersion := runtime.Version()
-	cmd.PrintfSuccess("Gadget version: %v", GADGET_VERSION)
-	cmd.PrintfSuccess("Go version: %v", goVersion)
+	cmd.Write = printGadgetOutput
 
 	setFlags()
 
@@ -34,13 +41,85 @@ func main() {
 		return
 	}
 
-	conf := getConfigWithFlags()
+	// gadget ctl talks to a running session, its output is meant for scripts
+	if flag.Arg(0) == "ctl" {
+		conf, err := getConfigWithFlags()
+		if err != nil {
+			cmd.PrintfDanger("%v", err)
+			os.Exit(1)
+		}
+
+		os.Exit(runCtl(conf, flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "config" {
+		resolveConfigFile()
+
+		os.Exit(runConfigCommand(flag.Args()[1:]))
+	}
+
+	goVersion := runtime.Version()
+	cmd.PrintfSuccess("Gadget version: %v", GADGET_VERSION)
+	cmd.PrintfSuccess("Go version: %v", goVersion)
+
+	conf, err := getConfigWithFlags()
+	if err != nil {
+		cmd.PrintfDanger("%v. Fix it and try again. Run gadget config check to check it without starting", err)
+		os.Exit(1)
+	}
 
-	builder := newBuilder(conf)
+	// gadget {plugin} runs a plugin instead of a session
+	if name := flag.Arg(0); name != "" && name != "dev" {
+		path, ok := findPlugins()[name]
+		if !ok {
+			cmd.PrintfDanger("Unknown command: %v", name)
+			os.Exit(2)
+		}
+
+		err := runPlugin(name, path, flag.Args()[1:], newPluginContext(newBuilders(conf)))
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		} else if err != nil {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	setLineFormat(conf.Output, conf.LogFormat)
+	scrollback = newLogBuffer(conf.Output.Scrollback)
+
+	if conf.LogFiles.Enabled {
+		logs, err := newLogFiles(conf.LogFiles)
+		if err != nil {
+			cmd.PrintfDanger("Failed to create log directory: %v", err)
+		} else {
+			sessionLogs = logs
+			sessionLogs.marker("gadget", "session started, gadget "+GADGET_VERSION)
+
+			if verbose > 0 {
+				cmd.PrintfInfo("Writing logs to %v", conf.LogFiles.Dir)
+			}
+		}
+	}
+
+	builders := newBuilders(conf)
+	processes := newProcesses(conf)
+	units := newUnits(conf, builders, processes)
+
+	watcher := newWatcher(conf)
+
+	gsh := newGadgetShell(builders, processes, units, &watcher, conf)
+	control := startControl(gsh, conf)
+
+	gsh.watchConfig()
 
 	// in case of panic
 	defer func() {
-		cmd.KillPid(builder.debugger.Process.Pid)
+		stopUnits(gsh.units)
+		control.close()
 	}()
 
 	// Clean up before exiting
@@ -48,33 +127,31 @@ func main() {
 	signal.Notify(quitChannel, os.Interrupt)
 	go func() {
 		<-quitChannel
-		if builder.debugger != nil {
-			cmd.PrintfInfo("\nEnding debugger process...")
-			cmd.KillPid(builder.debugger.Process.Pid)
+		// ^C while a shell command runs is for the command
+		for passthroughRunning.Load() {
+			<-quitChannel
 		}
 
-		if builder.runningBinary != nil {
-			cmd.PrintfInfo("Ending application process...")
-			cmd.KillPid(builder.runningBinary.Process.Pid)
-		}
+		println()
+		stopUnits(gsh.units)
+		control.close()
+		sessionLogs.close()
 
 		os.Exit(0)
 	}()
 
-	watcher := newWatcher(conf)
+	gsh.pickBuildPackages(gsh.editor)
 
 	if flag.Arg(0) == "dev" {
 		cmd.PrintfInfo("Building...")
 
-		builder.runBuildDebug()
+		startUnits(units, units)
 
 		cmd.PrintfInfo("Watching files")
 
-		runWatcher(&watcher, &builder)
+		runWatcher(&watcher, gsh)
 	}
 
-	gsh := newGadgetShell(&builder, &watcher, conf)
-
 	go gsh.run()
 
 	cmd.PrintfInfo("^C to exit")
@@ -90,39 +167,121 @@ func setFlags() {
 
 	flag.IntVar(&verbose, "v", 0, "-v 1\n\tIncrease verbosity")
 
+	flag.StringVar(&configFile, "config", "", "-config /path/to/gadget.toml\n\tDefaults to gadget.toml in the current directory or the closest parent with one")
+
 	flag.StringVar(&appPath, "path", "", "-path /path/to/project")
 
 	flag.StringVar(&binaryName, "binary", "", "-binary name")
 
 	flag.IntVar(&listenPort, "listen", 0, "-listen 2345")
 
+	flag.StringVar(&profileName, "profile", "", "-profile name\n\tOverlays the [profile.name] table of gadget.toml on the config")
+
 	flag.Parse()
 }
 
-func getConfigWithFlags() config.Config {
-	conf := config.GetConfig("gadget.toml")
+// resolveConfigFile picks the config file: the one given with -config, or else gadget.toml in the working directory
+// or the closest of its parents.
+func resolveConfigFile() {
+	if configFile == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			panic(cmd.FormatDanger(err.Error()))
+		}
 
-	if appPath != "" {
-		conf.Path = appPath
+		found, ok := config.Find(wd)
+		if ok {
+			configFile = found
+		} else {
+			configFile = filepath.Join(wd, config.FileName)
+		}
 	}
 
-	if binaryName != "" {
-		conf.Name = binaryName
+	absConfigFile, err := filepath.Abs(configFile)
+	if err == nil {
+		configFile = absConfigFile
 	}
+}
+
+// getConfigWithFlags loads the config with the command line flags applied. It returns an error when the config has
+// problems, the caller decides how gadget exits.
+func getConfigWithFlags() (config.Config, error) {
+	resolveConfigFile()
 
-	if listenPort != 0 {
-		conf.ListenPort = listenPort
+	conf, err := config.GetConfigWithFlags(configFile, configFlags())
+	if err != nil {
+		return conf, err
 	}
 
-	return conf
+	if verbose > 0 {
+		printResolvedConfig(conf)
+	}
+
+	return conf, nil
+}
+
+// configFlags are the command line flags that override the config file.
+func configFlags() config.Flags {
+	return config.Flags{
+		Path:       appPath,
+		Name:       binaryName,
+		ListenPort: listenPort,
+		Profile:    profileName,
+	}
 }
 
-func runWatcher(watcher *watcher, builder *builder) {
-	watcher.onEvent = func() {
+// printResolvedConfig shows where the config came from and the absolute paths it resolved to.
+func printResolvedConfig(conf config.Config) {
+	cmd.PrintfInfo("Config file: %v", configFile)
+
+	if conf.Profile != "" {
+		cmd.PrintfInfo("Profile: %v", conf.Profile)
+	}
+	cmd.PrintfInfo("app_path: %v", conf.Path)
+
+	lists := []struct {
+		key   string
+		paths []string
+	}{
+		{"exclude_dirs", conf.ExcludeDirs},
+		{"exclude_files", conf.ExcludeFiles},
+		{"include_dirs", conf.IncludeDirs},
+		{"include_files", conf.IncludeFiles},
+	}
+
+	for _, list := range lists {
+		if len(list.paths) > 0 {
+			cmd.PrintfInfo("%v: %v", list.key, strings.Join(list.paths, ", "))
+		}
+	}
+
+	if conf.LogFiles.Enabled {
+		cmd.PrintfInfo("log_files.dir: %v", conf.LogFiles.Dir)
+	}
+}
+
+// runWatcher rebuilds the services affected by changes. It uses the shell's services at the time of a change, so
+// they can change with the config while the watcher runs. Rebuilds wait for commands and reloads to finish.
+func runWatcher(watcher *watcher, gsh *gadgetShell) {
+	watcher.onEvent = func(changed []string) {
+		commandMu.Lock()
+		defer commandMu.Unlock()
+
+		sessionLogs.write("gadget", "changed: "+strings.Join(changed, ", "), time.Now())
+
+		affected := changedBuilders(gsh.builders, changed)
+		if len(affected) == 0 {
+			cmd.Write("\n")
+			cmd.PrintfInfo("No service imports the changed files")
+			printPrompt()
+
+			return
+		}
+
 		// recompile
-		println("\nRebuilding")
+		cmd.Write("\nRebuilding\n")
 
-		builder.runBuildDebug()
+		startUnits(gsh.units, buildersAsUnits(affected))
 
 		printPrompt()
 	}
@@ -131,3 +290,45 @@ func runWatcher(watcher *watcher, builder *builder) {
 		watcher.watch()
 	}()
 }
+
+// changedBuilders returns the builders to rebuild for changed paths. Go files no service imports reload the imports
+// first: they're only listed after a successful build, so they miss a package the app just started importing.
+func changedBuilders(builders []*builder, changed []string) []*builder {
+	affected := affectedBuilders(builders, changed)
+	if len(affected) > 0 {
+		return affected
+	}
+
+	for _, builder := range builders {
+		builder.loadDeps()
+	}
+
+	return affectedBuilders(builders, changed)
+}
+
+// affectedBuilders returns the builders of services importing a changed path. Go files outside every service's
+// packages, like another main package, rebuild nothing, other changes like assets rebuild everything.
+func affectedBuilders(builders []*builder, changed []string) []*builder {
+	affected := make([]*builder, 0)
+	for _, builder := range builders {
+		for _, path := range changed {
+			if builder.isAffectedBy(path) {
+				affected = append(affected, builder)
+
+				break
+			}
+		}
+	}
+
+	if len(affected) > 0 {
+		return affected
+	}
+
+	for _, path := range changed {
+		if filepath.Ext(path) != ".go" {
+			return builders
+		}
+	}
+
+	return affected
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partial lines, like an app's own prompt, are printed once nothing more arrived for this long
+const partialLineFlush = 200 * time.Millisecond
+
+// console serializes everything printed to the terminal, and redraws the shell prompt when output arrives while the
+// shell is waiting for input, instead of printing in the middle of the prompt.
+type console struct {
+	mu          sync.Mutex
+	promptShown bool
+	// the prompt line as it should be drawn, including anything typed so far
+	promptLine func() string
+	// the terminal's state before the line editor made it raw, nil when it isn't raw
+	rawState *term.State
+}
+
+var terminal = &console{
+	promptLine: promptText,
+}
+
+func (c *console) print(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rawState != nil {
+		// raw mode doesn't return the carriage on newlines
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+
+	if c.promptShown {
+		// clear the prompt line, print, then show the prompt again under the output
+		_, _ = fmt.Fprint(os.Stderr, "\r\033[K"+text)
+		_, _ = fmt.Fprint(os.Stderr, c.promptLine())
+
+		return
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, text)
+}
+
+// showPrompt prints the prompt, replacing one already shown.
+func (c *console) showPrompt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.promptShown {
+		_, _ = fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, c.promptLine())
+	c.promptShown = true
+}
+
+// promptAnswered is called once the shell read a line, output no longer needs to make room for the prompt.
+func (c *console) promptAnswered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promptShown = false
+}
+
+func (c *console) setPromptLine(promptLine func() string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promptLine = promptLine
+}
+
+// makeRaw puts the terminal in raw mode, for the line editor to handle every key.
+func (c *console) makeRaw(fd int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rawState != nil {
+		return nil
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+
+	c.rawState = state
+
+	return nil
+}
+
+// restore undoes makeRaw.
+func (c *console) restore(fd int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rawState != nil {
+		_ = term.Restore(fd, c.rawState)
+		c.rawState = nil
+	}
+}
+
+// how lines from the app, the debugger and other processes are printed
+var lineFormat = cmd.LineFormat{Prefix: true, ColorStderr: true}
+
+// renders structured log lines when enabled
+var logFormatter *cmd.LogFormatter
+
+func setLineFormat(output config.Output, logFormat config.LogFormat) {
+	lineFormat = cmd.LineFormat{
+		Prefix:      output.Prefix,
+		Timestamps:  output.Timestamps,
+		ColorStderr: output.StderrColor,
+	}
+
+	logFormatter = nil
+	if logFormat.Pretty {
+		logFormatter = &cmd.LogFormatter{
+			MinLevel: logFormat.MinLevel,
+			HideKeys: logFormat.HideKeys,
+		}
+	}
+}
+
+// sourcePrinter returns a function printing the lines of source with the configured format. Lines are kept in the
+// scrollback.
+func sourcePrinter(source string, color string, isStderr bool) func(string) {
+	return func(line string) {
+		entry := logEntry{
+			source:    source,
+			color:     color,
+			isStderr:  isStderr,
+			line:      line,
+			printedAt: time.Now(),
+		}
+
+		scrollback.add(entry)
+		sessionLogs.write(source, line, entry.printedAt)
+
+		printLogEntry(entry)
+	}
+}
+
+func printLogEntry(entry logEntry) {
+	formatted := formatLogEntry(entry)
+	if formatted != "" {
+		terminal.print(formatted)
+	}
+}
+
+// formatLogEntry formats an entry with the configured formats. It's empty when the log formatter hides the entry.
+func formatLogEntry(entry logEntry) string {
+	line := entry.line
+	colorAsStderr := entry.isStderr
+	if logFormatter != nil {
+		formatted, show := logFormatter.Format(line)
+		if !show {
+			return ""
+		}
+
+		// structured lines are colored by their level instead
+		if formatted != line {
+			line = formatted
+			colorAsStderr = false
+		}
+	}
+
+	return lineFormat.FormatLine(entry.source, entry.color, colorAsStderr, line, entry.printedAt)
+}
+
+// printGadgetOutput prints gadget's own messages, and writes them to the session's log files.
+func printGadgetOutput(text string) {
+	terminal.print(text)
+	sessionLogs.write("gadget", text, time.Now())
+}
+
+// printReadCloser prints every line read from readCloser until it's closed.
+func printReadCloser(readCloser io.ReadCloser, printFunc func(string)) {
+	cmd.ReadLines(readCloser, partialLineFlush, printFunc)
+}
+
+// printOutput prints the lines of a process's stdout and stderr as source. The channel is closed once both are read to
+// the end: exec.Cmd.Wait closes the pipes, so it's only called after that, or the last lines are lost.
+func printOutput(stdOut io.ReadCloser, stdErr io.ReadCloser, source string, color string) <-chan struct{} {
+	var readers sync.WaitGroup
+	readers.Add(2)
+
+	go func() {
+		defer readers.Done()
+		printReadCloser(stdOut, sourcePrinter(source, color, false))
+	}()
+
+	go func() {
+		defer readers.Done()
+		printReadCloser(stdErr, sourcePrinter(source, color, true))
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+
+	return done
+}
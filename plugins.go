@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/clanko/gadget/cmd"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// executables named gadget-{name} become the command {name}
+const pluginPrefix = "gadget-"
+
+// pluginCommand runs an executable found in ~/.clanko-gadget-cli/plugins or on PATH, passing it the session's
+// context in environment variables.
+type pluginCommand struct {
+	gsh  *gadgetShell
+	name string
+	path string
+}
+
+// pluginContext is the session as plugins see it, in GADGET_* environment variables and as JSON in GADGET_CONTEXT.
+type pluginContext struct {
+	Address    string                   `json:"address"`
+	DebugPort  int                      `json:"debug_port"`
+	Pid        int                      `json:"pid"`
+	ConfigPath string                   `json:"config_path"`
+	BinaryPath string                   `json:"binary_path"`
+	AppPath    string                   `json:"app_path"`
+	Services   map[string]pluginService `json:"services"`
+}
+
+type pluginService struct {
+	Address    string `json:"address"`
+	DebugPort  int    `json:"debug_port"`
+	Pid        int    `json:"pid"`
+	BinaryPath string `json:"binary_path"`
+}
+
+func (command pluginCommand) info() commandInfo {
+	return commandInfo{
+		name:     command.name,
+		synopsis: "Plugin " + command.path,
+		args:     "[args...]",
+		minArgs:  0,
+		maxArgs:  -1,
+	}
+}
+
+func (command pluginCommand) execute(input lineReader, args []string) {
+	_ = runPlugin(command.name, command.path, args, newPluginContext(command.gsh.builders))
+}
+
+// runPlugin runs a plugin attached to the terminal with the session's context.
+func runPlugin(name string, path string, args []string, context pluginContext) error {
+	plugin := exec.Command(path, args...)
+	plugin.Env = append(os.Environ(), context.environment()...)
+
+	return runAttached(name, plugin)
+}
+
+func newPluginContext(builders []*builder) pluginContext {
+	context := pluginContext{
+		ConfigPath: configFile,
+		Services:   make(map[string]pluginService),
+	}
+
+	for i, builder := range builders {
+		service := pluginService{
+			Address:    builder.config.Address,
+			DebugPort:  builder.port,
+			BinaryPath: builder.config.Path + "/" + builder.config.Name,
+		}
+
+		if builder.isRunning() {
+			service.Pid = builder.runningBinary.Process.Pid
+		}
+
+		context.Services[builder.name()] = service
+
+		if i == 0 {
+			context.Address = service.Address
+			context.DebugPort = service.DebugPort
+			context.Pid = service.Pid
+			context.BinaryPath = service.BinaryPath
+			context.AppPath = builder.config.Path
+		}
+	}
+
+	return context
+}
+
+func (context pluginContext) environment() []string {
+	contextJson, err := json.Marshal(context)
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+	}
+
+	return []string{
+		"GADGET_ADDRESS=" + context.Address,
+		"GADGET_DEBUG_PORT=" + strconv.Itoa(context.DebugPort),
+		"GADGET_PID=" + strconv.Itoa(context.Pid),
+		"GADGET_CONFIG=" + context.ConfigPath,
+		"GADGET_BINARY=" + context.BinaryPath,
+		"GADGET_APP_PATH=" + context.AppPath,
+		"GADGET_VERSION=" + GADGET_VERSION,
+		"GADGET_CONTEXT=" + string(contextJson),
+	}
+}
+
+// findPlugins maps plugin names to executables. The plugins directory comes first, then PATH in order, like a shell
+// looks up commands.
+func findPlugins() map[string]string {
+	plugins := make(map[string]string)
+
+	dirs := make([]string, 0)
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		dirs = append(dirs, home+"/"+gadgetCliConfigDir+"/plugins")
+	}
+
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name, isPlugin := strings.CutPrefix(entry.Name(), pluginPrefix)
+			if !isPlugin || name == "" {
+				continue
+			}
+
+			if _, found := plugins[name]; found {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+
+			// follows symlinks
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+
+			plugins[name] = path
+		}
+	}
+
+	return plugins
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindPlugins(t *testing.T) {
+	home := t.TempDir()
+	pathDir := t.TempDir()
+
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", pathDir)
+
+	pluginsDir := filepath.Join(home, gadgetCliConfigDir, "plugins")
+
+	err := os.MkdirAll(pluginsDir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]os.FileMode{
+		filepath.Join(pluginsDir, "gadget-deploy"): 0755,
+		filepath.Join(pathDir, "gadget-deploy"):    0755,
+		filepath.Join(pathDir, "gadget-lint"):      0755,
+		filepath.Join(pathDir, "gadget-notes"):     0644,
+		filepath.Join(pathDir, "other"):            0755,
+	}
+
+	for path, mode := range files {
+		err = os.WriteFile(path, []byte("#!/bin/sh\n"), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plugins := findPlugins()
+
+	if len(plugins) != 2 {
+		t.Errorf("Expected 2 plugins, got %v", plugins)
+	}
+
+	if plugins["deploy"] != filepath.Join(pluginsDir, "gadget-deploy") {
+		t.Errorf("Expected the plugins directory to come before PATH, got %v", plugins["deploy"])
+	}
+
+	if plugins["lint"] == "" {
+		t.Errorf("Expected gadget-lint on PATH to be found")
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/google/pprof/profile"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// pprofKinds are the profiles the pprof command captures, named like their net/http/pprof handler, except cpu.
+var pprofKinds = []string{"cpu", "heap", "goroutine", "allocs", "block", "mutex", "threadcreate"}
+
+// defaultCPUDuration is how long a cpu profile records without a duration.
+const defaultCPUDuration = 30 * time.Second
+
+// pprofCommand captures a pprof profile of a running service.
+type pprofCommand struct {
+	gsh *gadgetShell
+}
+
+func (command pprofCommand) info() commandInfo {
+	return commandInfo{
+		name:     "pprof",
+		synopsis: "Captures a pprof profile of the running app",
+		args:     "{cpu|heap|goroutine|allocs|block|mutex|threadcreate} [duration] [service] [--diff]",
+		details: "Fetches the profile from the service's pprof endpoint, like pprof cpu 30s, pprof heap or pprof " +
+			"goroutine, saves it in a timestamped directory of pprof.dir and prints its top functions. A cpu profile " +
+			"records for 30s without a duration. --diff also shows how they changed since the previous capture of the kind.",
+		minArgs: 1,
+		maxArgs: 4,
+	}
+}
+
+func (command pprofCommand) execute(input lineReader, args []string) {
+	if !isPprofKind(args[0]) {
+		cmd.PrintfWarning("Unknown profile %v, use one of %v", args[0], strings.Join(pprofKinds, ", "))
+
+		return
+	}
+
+	command.gsh.captureProfile(args)
+}
+
+func isPprofKind(name string) bool {
+	return slices.Contains(pprofKinds, name)
+}
+
+// captureRequest is a pprof command asking for a capture: pprof <kind> [duration] [service] [--diff].
+type captureRequest struct {
+	kind     string
+	duration time.Duration
+	service  string
+	diff     bool
+}
+
+func parseCaptureRequest(args []string) (captureRequest, error) {
+	request := captureRequest{kind: args[0]}
+
+	for _, arg := range args[1:] {
+		if arg == "--diff" || arg == "-diff" {
+			request.diff = true
+
+			continue
+		}
+
+		if duration, err := time.ParseDuration(arg); err == nil {
+			if duration <= 0 {
+				return request, fmt.Errorf("the duration %v should be positive", arg)
+			}
+
+			// pprof records whole seconds
+			request.duration = (duration + time.Second - 1).Truncate(time.Second)
+
+			continue
+		}
+
+		if request.service != "" {
+			return request, fmt.Errorf("profile one service at a time, got %v and %v", request.service, arg)
+		}
+
+		request.service = arg
+	}
+
+	if request.kind == "cpu" && request.duration == 0 {
+		request.duration = defaultCPUDuration
+	}
+
+	return request, nil
+}
+
+// captureProfile fetches a profile from a running service's pprof endpoint, saves it in a timestamped directory of
+// pprof.dir and prints its top functions, and with --diff how they changed since the previous capture of the kind.
+func (gsh *gadgetShell) captureProfile(args []string) {
+	request, err := parseCaptureRequest(args)
+	if err != nil {
+		cmd.PrintfWarning("%v", err)
+
+		return
+	}
+
+	builder := gsh.profiledBuilder(request.service)
+	if builder == nil {
+		return
+	}
+
+	if !builder.isRunning() {
+		cmd.PrintfWarning("%v isn't running", builder.name())
+
+		return
+	}
+
+	pprof := builder.config.Pprof
+
+	url := "http://" + builder.pprofAddress() + strings.TrimSuffix(pprof.Path, "/") + "/" + request.endpoint()
+	if request.duration > 0 {
+		url += "?seconds=" + strconv.Itoa(int(request.duration.Seconds()))
+		cmd.PrintfInfo("Capturing %v of the %v profile of %v...", request.duration, request.kind, builder.name())
+	}
+
+	data, err := fetchProfile(url, request.duration+30*time.Second)
+	if err != nil {
+		cmd.PrintfWarning("%v", err)
+
+		return
+	}
+
+	captured, err := profile.ParseData(data)
+	if err != nil {
+		cmd.PrintfWarning("%v isn't a profile: %v", url, err)
+
+		return
+	}
+
+	name := builder.name() + "-" + request.kind + ".pb.gz"
+
+	dir, err := newCaptureDir(pprof.Dir)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+
+	if err != nil {
+		cmd.PrintfDanger("Failed to save the profile: %v", err)
+
+		return
+	}
+
+	cmd.Write(formatTop(fmt.Sprintf("%v profile of %v", request.kind, builder.name()), captured, pprof.Top, false))
+
+	if request.diff {
+		printProfileDiff(captured, previousCapture(pprof.Dir, name, dir), pprof.Top)
+	}
+
+	file := filepath.Join(dir, name)
+	cmd.PrintfInfo("Saved to %v, explore it with go tool pprof -http=localhost:0 %v", file, file)
+}
+
+// profiledBuilder is the service to profile: the one named, or the only one.
+func (gsh *gadgetShell) profiledBuilder(name string) *builder {
+	if name != "" {
+		selected := gsh.selectBuilders([]string{name})
+		if len(selected) == 0 {
+			return nil
+		}
+
+		return selected[0]
+	}
+
+	if len(gsh.builders) > 1 {
+		names := make([]string, 0, len(gsh.builders))
+		for _, builder := range gsh.builders {
+			names = append(names, builder.name())
+		}
+
+		cmd.PrintfWarning("Name the service to profile: %v", strings.Join(names, ", "))
+
+		return nil
+	}
+
+	return gsh.builders[0]
+}
+
+// endpoint is the net/http/pprof handler serving the kind.
+func (request captureRequest) endpoint() string {
+	if request.kind == "cpu" {
+		return "profile"
+	}
+
+	return request.kind
+}
+
+// pprofAddress is where the service serves pprof: pprof.address, or else its own address.
+func (b *builder) pprofAddress() string {
+	address := b.config.Pprof.Address
+	if address == "" {
+		address = b.config.Address
+	}
+
+	// an app listening on every interface, like :8080
+	if host, port, err := net.SplitHostPort(address); err == nil && host == "" {
+		return net.JoinHostPort("localhost", port)
+	}
+
+	return address
+}
+
+func fetchProfile(url string, timeout time.Duration) ([]byte, error) {
+	client := http.Client{Timeout: timeout}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %v: %v", url, err)
+	}
+
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %v: %v", url, err)
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%v isn't served, import net/http/pprof in the app or set [pprof] address and path", url)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v answered %v: %v", url, response.Status, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
+
+// newCaptureDir creates a capture directory in dir, named by the time it's taken. Captures in the same millisecond get a
+// counter rather than sharing one.
+func newCaptureDir(dir string) (string, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	stamp := time.Now().Format("20060102-150405.000")
+
+	for i := 1; ; i++ {
+		capture := filepath.Join(dir, stamp)
+		if i > 1 {
+			capture += fmt.Sprintf("-%03d", i)
+		}
+
+		err = os.Mkdir(capture, os.ModePerm)
+		if !os.IsExist(err) {
+			return capture, err
+		}
+	}
+}
+
+// previousCapture is the last profile saved under name in one of dir's capture directories other than current, empty
+// without one.
+func previousCapture(dir string, name string, current string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	// timestamps sort in the order they were taken
+	for i := len(entries) - 1; i >= 0; i-- {
+		capture := filepath.Join(dir, entries[i].Name())
+		if !entries[i].IsDir() || capture == current {
+			continue
+		}
+
+		file := filepath.Join(capture, name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+func printProfileDiff(captured *profile.Profile, previous string, top int) {
+	if previous == "" {
+		cmd.PrintfInfo("No earlier capture to diff against")
+
+		return
+	}
+
+	data, err := os.ReadFile(previous)
+	if err != nil {
+		cmd.PrintfWarning("%v", err)
+
+		return
+	}
+
+	base, err := profile.ParseData(data)
+	if err != nil {
+		cmd.PrintfWarning("%v isn't a profile: %v", previous, err)
+
+		return
+	}
+
+	diff, err := diffProfiles(captured, base)
+	if err != nil {
+		cmd.PrintfWarning("Can't diff against %v: %v", previous, err)
+
+		return
+	}
+
+	cmd.Write(formatTop("Change since "+filepath.Base(filepath.Dir(previous)), diff, top, true))
+}
+
+// diffProfiles subtracts base from captured, the way go tool pprof -diff_base does.
+func diffProfiles(captured *profile.Profile, base *profile.Profile) (*profile.Profile, error) {
+	base = base.Copy()
+	base.Scale(-1)
+
+	return profile.Merge([]*profile.Profile{captured.Copy(), base})
+}
+
+// topFunction is a function's share of a profile: flat in the function itself, cum with the functions it calls.
+type topFunction struct {
+	name string
+	flat int64
+	cum  int64
+}
+
+// topFunctions returns the n functions with the largest flat values of the profile's default sample type, and its
+// total.
+func topFunctions(p *profile.Profile, n int) ([]topFunction, int64, *profile.ValueType) {
+	index := len(p.SampleType) - 1
+	for i, sampleType := range p.SampleType {
+		if sampleType.Type == p.DefaultSampleType {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return nil, 0, &profile.ValueType{}
+	}
+
+	functions := make(map[string]*topFunction)
+	function := func(name string) *topFunction {
+		if functions[name] == nil {
+			functions[name] = &topFunction{name: name}
+		}
+
+		return functions[name]
+	}
+
+	var total int64
+	for _, sample := range p.Sample {
+		value := sample.Value[index]
+		total += value
+
+		// a recursive function counts once in a stack
+		seen := make(map[string]bool)
+		for i, location := range sample.Location {
+			for j, name := range locationNames(location) {
+				if i == 0 && j == 0 {
+					function(name).flat += value
+				}
+
+				if !seen[name] {
+					function(name).cum += value
+					seen[name] = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]topFunction, 0, len(functions))
+	for _, function := range functions {
+		if function.flat != 0 || function.cum != 0 {
+			sorted = append(sorted, *function)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if abs(sorted[i].flat) != abs(sorted[j].flat) {
+			return abs(sorted[i].flat) > abs(sorted[j].flat)
+		}
+
+		if abs(sorted[i].cum) != abs(sorted[j].cum) {
+			return abs(sorted[i].cum) > abs(sorted[j].cum)
+		}
+
+		return sorted[i].name < sorted[j].name
+	})
+
+	return sorted[:min(n, len(sorted))], total, p.SampleType[index]
+}
+
+// locationNames are the functions of a location, the inlined ones first. Without symbols, it's the address.
+func locationNames(location *profile.Location) []string {
+	if len(location.Line) == 0 {
+		return []string{fmt.Sprintf("%#x", location.Address)}
+	}
+
+	names := make([]string, 0, len(location.Line))
+	for _, line := range location.Line {
+		if line.Function != nil {
+			names = append(names, line.Function.Name)
+		}
+	}
+
+	return names
+}
+
+// formatTop renders the top functions of a profile as a table. A diff shows signed changes rather than shares.
+func formatTop(title string, p *profile.Profile, n int, diff bool) string {
+	functions, total, sampleType := topFunctions(p, n)
+
+	var buffer bytes.Buffer
+
+	if diff {
+		fmt.Fprintf(&buffer, "%v, %v %v in total\n", title, signed(formatSampleValue(total, sampleType.Unit), total), sampleType.Type)
+	} else {
+		fmt.Fprintf(&buffer, "Top %v of the %v, %v %v in total\n", len(functions), title, formatSampleValue(total, sampleType.Unit), sampleType.Type)
+	}
+
+	writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', tabwriter.AlignRight)
+
+	if diff {
+		fmt.Fprintf(writer, "flat\tcum\t\n")
+	} else {
+		fmt.Fprintf(writer, "flat\tflat%%\tcum\tcum%%\t\n")
+	}
+
+	for _, function := range functions {
+		flat := formatSampleValue(function.flat, sampleType.Unit)
+		cum := formatSampleValue(function.cum, sampleType.Unit)
+
+		if diff {
+			fmt.Fprintf(writer, "%v\t%v\t  %v\n", signed(flat, function.flat), signed(cum, function.cum), function.name)
+		} else {
+			fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t  %v\n", flat, percent(function.flat, total), cum, percent(function.cum, total), function.name)
+		}
+	}
+
+	_ = writer.Flush()
+
+	return buffer.String()
+}
+
+// formatSampleValue renders a sample value in its unit, like 1.25s or 3.5MB.
+func formatSampleValue(value int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		duration := time.Duration(value)
+		if abs(value) >= int64(time.Millisecond) {
+			duration = duration.Round(10 * time.Microsecond)
+		}
+
+		return duration.String()
+
+	case "bytes":
+		size := float64(value)
+		for _, suffix := range []string{"B", "kB", "MB", "GB"} {
+			if size < 1024 && size > -1024 || suffix == "GB" {
+				if suffix == "B" {
+					return fmt.Sprintf("%.0f%v", size, suffix)
+				}
+
+				return fmt.Sprintf("%.1f%v", size, suffix)
+			}
+
+			size /= 1024
+		}
+	}
+
+	return strconv.FormatInt(value, 10)
+}
+
+func percent(value int64, total int64) string {
+	if total == 0 {
+		return "0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(value)*100/float64(total))
+}
+
+func signed(formatted string, value int64) string {
+	if value > 0 {
+		return "+" + formatted
+	}
+
+	return formatted
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
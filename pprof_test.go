@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/google/pprof/profile"
+	"net/http/httptest"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCaptureRequest(t *testing.T) {
+	request, err := parseCaptureRequest([]string{"cpu", "api", "--diff"})
+	if err != nil || request.duration != defaultCPUDuration || request.service != "api" || !request.diff {
+		t.Errorf("Expected a 30s cpu capture of api with a diff, got %+v, %v", request, err)
+	}
+
+	request, err = parseCaptureRequest([]string{"heap", "5s"})
+	if err != nil || request.duration != 5*time.Second || request.endpoint() != "heap" {
+		t.Errorf("Expected a 5s heap capture, got %+v, %v", request, err)
+	}
+
+	request, err = parseCaptureRequest([]string{"cpu", "500ms"})
+	if err != nil || request.duration != time.Second {
+		t.Errorf("Expected a sub-second duration to record 1s, got %+v, %v", request, err)
+	}
+
+	if _, err = parseCaptureRequest([]string{"goroutine", "api", "worker"}); err == nil {
+		t.Errorf("Expected an error for two services")
+	}
+}
+
+func TestTopFunctions(t *testing.T) {
+	newProfile := func(handler int64, query int64) *profile.Profile {
+		app := &profile.Function{ID: 1, Name: "main.main"}
+		handle := &profile.Function{ID: 2, Name: "main.handle"}
+		sql := &profile.Function{ID: 3, Name: "db.query"}
+
+		mainLocation := &profile.Location{ID: 1, Line: []profile.Line{{Function: app}}}
+		handleLocation := &profile.Location{ID: 2, Line: []profile.Line{{Function: handle}}}
+		queryLocation := &profile.Location{ID: 3, Line: []profile.Line{{Function: sql}}}
+
+		return &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+			PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{handleLocation, mainLocation}, Value: []int64{handler}},
+				{Location: []*profile.Location{queryLocation, handleLocation, mainLocation}, Value: []int64{query}},
+			},
+			Location: []*profile.Location{mainLocation, handleLocation, queryLocation},
+			Function: []*profile.Function{app, handle, sql},
+		}
+	}
+
+	functions, total, _ := topFunctions(newProfile(100, 300), 2)
+	if total != 400 || len(functions) != 2 {
+		t.Fatalf("Expected the top 2 of 400, got %+v of %v", functions, total)
+	}
+
+	if functions[0].name != "db.query" || functions[0].flat != 300 || functions[1].name != "main.handle" || functions[1].cum != 400 {
+		t.Errorf("Expected db.query then main.handle, got %+v", functions)
+	}
+
+	diff, err := diffProfiles(newProfile(100, 500), newProfile(100, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	functions, total, _ = topFunctions(diff, 10)
+	if total != 200 || len(functions) != 3 || functions[0].name != "db.query" || functions[0].flat != 200 {
+		t.Errorf("Expected db.query to take 200 more, got %+v of %v", functions, total)
+	}
+}
+
+func TestFetchProfile(t *testing.T) {
+	server := httptest.NewServer(pprof.Handler("goroutine"))
+	defer server.Close()
+
+	data, err := fetchProfile(server.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goroutines, err := profile.ParseData(data)
+	if err != nil || len(goroutines.Sample) == 0 {
+		t.Errorf("Expected a goroutine profile, got %v", err)
+	}
+}
+
+func TestPreviousCapture(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := newCaptureDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := newCaptureDir(dir)
+	if err != nil || second == first {
+		t.Fatalf("Expected a capture directory of its own, got %v and %v, %v", first, second, err)
+	}
+
+	for _, capture := range []string{first, second} {
+		if err = os.WriteFile(filepath.Join(capture, "api-heap.pb.gz"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if previous := previousCapture(dir, "api-heap.pb.gz", second); previous != filepath.Join(first, "api-heap.pb.gz") {
+		t.Errorf("Expected the first capture, got %q", previous)
+	}
+
+	if previous := previousCapture(dir, "api-cpu.pb.gz", second); previous != "" {
+		t.Errorf("Expected no earlier cpu capture, got %q", previous)
+	}
+}
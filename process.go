@@ -0,0 +1,237 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// auxProcess is a non-Go command run next to the app, like a frontend dev server or a mock server.
+type auxProcess struct {
+	config  config.Process
+	appPath string
+	color   string
+	command *exec.Cmd
+	running bool
+	// when the command started, and how it exited, empty while it runs
+	startedAt time.Time
+	exitState string
+	// set while gadget stops the process, so it isn't restarted
+	stopping bool
+	restarts int
+	mu       sync.Mutex
+}
+
+func newProcesses(conf config.Config) []*auxProcess {
+	processes := make([]*auxProcess, 0)
+	for _, processConfig := range conf.Processes {
+		color, ok := cmd.NamedColors[processConfig.Color]
+		if !ok {
+			color = cmd.SourceColor(processConfig.Name)
+		}
+
+		processes = append(processes, &auxProcess{
+			config:  processConfig,
+			appPath: conf.Path,
+			color:   color,
+		})
+	}
+
+	return processes
+}
+
+func (p *auxProcess) name() string {
+	return p.config.Name
+}
+
+func (p *auxProcess) dependencies() []string {
+	return p.config.DependsOn
+}
+
+func (p *auxProcess) isRunning() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.running
+}
+
+// start runs the process unless it's already running.
+func (p *auxProcess) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return
+	}
+
+	p.stopping = false
+	p.startLocked()
+}
+
+func (p *auxProcess) startLocked() {
+	dir := p.config.Dir
+	if dir == "" {
+		dir = p.appPath
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(p.appPath, dir)
+	}
+
+	p.command = exec.Command("sh", "-c", p.config.Command)
+	p.command.Dir = dir
+	p.command.Env = append(os.Environ(), p.config.Env...)
+	p.command.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	stdOut, err := p.command.StdoutPipe()
+	if err != nil {
+		cmd.PrintfDanger(err.Error())
+	}
+
+	stdErr, err := p.command.StderrPipe()
+	if err != nil {
+		cmd.PrintfDanger(err.Error())
+	}
+
+	err = p.command.Start()
+	if err != nil {
+		cmd.PrintfDanger("Failed to start %v: %v", p.name(), err)
+
+		return
+	}
+
+	p.running = true
+	p.startedAt = time.Now()
+	p.exitState = ""
+
+	sessionLogs.marker(p.name(), "start")
+	cmd.PrintfSuccess("Started %v", p.name())
+	events.publish("start", p.name(), "")
+
+	if verbose > 0 {
+		cmd.PrintfInfo(p.name() + " pid: " + strconv.Itoa(p.command.Process.Pid))
+	}
+
+	output := printOutput(stdOut, stdErr, p.name(), p.color)
+
+	go p.wait(p.command, output, time.Now())
+
+	if p.config.Address != "" {
+		go p.announceReady(p.command)
+	}
+}
+
+// announceReady publishes a ready event once the process answers on its address.
+func (p *auxProcess) announceReady(command *exec.Cmd) {
+	if !waitForAddress(p.config.Address, p.config.HealthCheck, readyTimeout) {
+		return
+	}
+
+	p.mu.Lock()
+	current := p.command == command && p.running
+	p.mu.Unlock()
+
+	if current {
+		events.publish("ready", p.name(), p.config.Address)
+	}
+}
+
+// wait applies the restart policy once the process exits and its output is printed.
+func (p *auxProcess) wait(command *exec.Cmd, output <-chan struct{}, started time.Time) {
+	<-output
+	err := command.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// restarted or stopped in the meantime
+	if p.command != command {
+		return
+	}
+
+	p.running = false
+	p.exitState = command.ProcessState.String()
+
+	if p.stopping {
+		events.publish("stop", p.name(), command.ProcessState.String())
+
+		return
+	}
+
+	if err != nil {
+		cmd.PrintfDanger("%v exited: %v", p.name(), err)
+		events.publish("crash", p.name(), err.Error())
+	} else {
+		cmd.PrintfWarning("%v exited", p.name())
+		events.publish("exit", p.name(), command.ProcessState.String())
+	}
+
+	restart := p.config.Restart == "always" || (p.config.Restart == "on-failure" && err != nil)
+	if !restart {
+		return
+	}
+
+	// a process that ran for a while starts over with the shortest delay
+	if time.Since(started) > 10*time.Second {
+		p.restarts = 0
+	}
+
+	delay := time.Second << min(p.restarts, 5)
+	p.restarts++
+
+	cmd.PrintfInfo("Restarting %v in %v", p.name(), delay)
+
+	go func() {
+		time.Sleep(delay)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.command == command && !p.stopping && !p.running {
+			p.startLocked()
+		}
+	}()
+}
+
+func (p *auxProcess) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopping = true
+
+	if p.running && p.command != nil && p.command.Process != nil {
+		cmd.KillPid(p.command.Process.Pid)
+	}
+}
+
+func (p *auxProcess) restart() {
+	p.stop()
+
+	// give the process group a moment to release its ports
+	time.Sleep(100 * time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopping = false
+	p.restarts = 0
+	p.startLocked()
+}
+
+func (p *auxProcess) waitReady(timeout time.Duration) bool {
+	if !p.isRunning() {
+		return false
+	}
+
+	if p.config.Address == "" {
+		return true
+	}
+
+	return waitForAddress(p.config.Address, p.config.HealthCheck, timeout)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"testing"
+	"time"
+)
+
+func TestProcessRestartBackoff(t *testing.T) {
+	subscriber, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	process := &auxProcess{
+		config:  config.Process{Name: "flaky", Command: "exit 3", Restart: "on-failure"},
+		appPath: t.TempDir(),
+	}
+
+	process.start()
+	defer process.stop()
+
+	// the delay doubles with every restart of a process that keeps failing
+	var starts []time.Time
+
+	timeout := time.After(10 * time.Second)
+	for len(starts) < 3 {
+		select {
+		case published := <-subscriber:
+			if published.Source == "flaky" && published.Type == "start" {
+				starts = append(starts, published.Time)
+			}
+		case <-timeout:
+			t.Fatalf("Expected 3 starts, got %v", len(starts))
+		}
+	}
+
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		gap := starts[i+1].Sub(starts[i])
+		if gap < delay || gap > delay+time.Second {
+			t.Errorf("Expected restart %v after %v, got %v", i+1, delay, gap)
+		}
+	}
+
+	process.mu.Lock()
+	restarts := process.restarts
+	process.mu.Unlock()
+
+	if restarts != 2 {
+		t.Errorf("Expected 2 restarts, got %v", restarts)
+	}
+}
+
+func TestProcessWithoutRestart(t *testing.T) {
+	subscriber, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	process := &auxProcess{
+		config:  config.Process{Name: "once", Command: "exit 3"},
+		appPath: t.TempDir(),
+	}
+
+	process.start()
+	defer process.stop()
+
+	// longer than the shortest restart delay
+	timeout := time.After(1500 * time.Millisecond)
+	starts := 0
+
+	for waiting := true; waiting; {
+		select {
+		case published := <-subscriber:
+			if published.Source == "once" && published.Type == "start" {
+				starts++
+			}
+		case <-timeout:
+			waiting = false
+		}
+	}
+
+	process.mu.Lock()
+	running, restarts := process.running, process.restarts
+	process.mu.Unlock()
+
+	if starts != 1 || running || restarts != 0 {
+		t.Errorf("Expected the process to run once, got %v starts and %v restarts", starts, restarts)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+)
+
+// profileCommand switches the session to another [profile.<name>] of gadget.toml.
+type profileCommand struct {
+	gsh *gadgetShell
+}
+
+func (command profileCommand) info() commandInfo {
+	return commandInfo{
+		name:     "profile",
+		synopsis: "Lists the config profiles, or switches to one",
+		args:     "[name | -]",
+		details: "Without a name, lists the profiles of gadget.toml and marks the one in use. With a name, reloads " +
+			"the config with that profile and restarts what its settings change. - goes back to the config without a profile.",
+		minArgs: 0,
+		maxArgs: 1,
+	}
+}
+
+func (command profileCommand) execute(input lineReader, args []string) {
+	gsh := command.gsh
+
+	if len(args) == 0 {
+		names := gsh.config.ProfileNames()
+		if len(names) == 0 {
+			cmd.PrintfInfo("No profiles, add [profile.<name>] tables to %v", config.FileName)
+
+			return
+		}
+
+		for _, name := range names {
+			if name == gsh.config.Profile {
+				cmd.PrintfSuccess("* %v", name)
+			} else {
+				cmd.Write("  " + name + "\n")
+			}
+		}
+
+		return
+	}
+
+	name := args[0]
+	if name == "-" {
+		name = ""
+	}
+
+	flags := configFlags()
+	flags.Profile = name
+
+	conf, problems := config.Load(configFile, flags)
+	config.PrintProblems(configFile, problems)
+
+	if problems.HasErrors() {
+		cmd.PrintfWarning("Keeping profile %v", describeProfile(gsh.config.Profile))
+
+		return
+	}
+
+	profileName = name
+
+	gsh.applyConfig(conf)
+
+	cmd.PrintfSuccess("Using profile %v", describeProfile(name))
+}
+
+func describeProfile(name string) string {
+	if name == "" {
+		return "none, the base config"
+	}
+
+	return name
+}
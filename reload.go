@@ -0,0 +1,280 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// watchConfig reloads the config when gadget.toml, gadget.local.toml or the user's config.toml change. Their
+// directories are watched rather than the files, editors often save by replacing a file.
+func (gsh *gadgetShell) watchConfig() {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		cmd.PrintfWarning("Not watching %v for changes: %v", config.FileName, err)
+
+		return
+	}
+
+	files := map[string]bool{
+		configFile:                   true,
+		config.LocalFile(configFile): true,
+	}
+
+	if global := config.GlobalFile(); global != "" {
+		files[global] = true
+	}
+
+	for file := range files {
+		// a directory that doesn't exist has no config to reload
+		_ = fsWatcher.Add(filepath.Dir(file))
+	}
+
+	go func() {
+		var timer *time.Timer
+
+		for {
+			select {
+			case _, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+
+			case e, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+
+				if !files[e.Name] || e.Op == fsnotify.Chmod {
+					continue
+				}
+
+				// editors write a file in several steps
+				if timer == nil {
+					timer = time.AfterFunc(300*time.Millisecond, gsh.reloadConfig)
+				} else {
+					timer.Reset(300 * time.Millisecond)
+				}
+			}
+		}
+	}()
+}
+
+// reloadConfig loads the config again and applies it. An invalid config is reported and the session keeps running
+// with the config it has.
+func (gsh *gadgetShell) reloadConfig() {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+
+	defer printPrompt()
+
+	cmd.Write("\n")
+
+	if _, err := os.Stat(configFile); err != nil {
+		cmd.PrintfWarning("%v was removed, keeping the config in use", configFile)
+
+		return
+	}
+
+	conf, problems := config.Load(configFile, configFlags())
+	config.PrintProblems(configFile, problems)
+
+	if problems.HasErrors() {
+		cmd.PrintfWarning("Keeping the config in use until %v is fixed", config.FileName)
+
+		return
+	}
+
+	gsh.applyConfig(conf)
+}
+
+// runSettings are the settings of a service that need it rebuilt and restarted when they change.
+type runSettings struct {
+	Name         string
+	ServiceName  string
+	Path         string
+	Address      string
+	AddressFlag  string
+	BuildArgs    []string
+	BuildPackage string
+	Variant      string
+	ListenPort   int
+	ListenHost   string
+	Args         []string
+	Env          []string
+	DependsOn    []string
+	HealthCheck  string
+}
+
+func newRunSettings(conf config.Config) runSettings {
+	return runSettings{
+		Name:         conf.Name,
+		ServiceName:  conf.ServiceName,
+		Path:         conf.Path,
+		Address:      conf.Address,
+		AddressFlag:  conf.AddressFlag,
+		BuildArgs:    conf.BuildArgs,
+		BuildPackage: conf.BuildPackage,
+		Variant:      conf.Variant,
+		ListenPort:   conf.ListenPort,
+		ListenHost:   conf.ListenHost,
+		Args:         conf.Args,
+		Env:          conf.Env,
+		DependsOn:    conf.DependsOn,
+		HealthCheck:  conf.HealthCheck,
+	}
+}
+
+// watchSettings are the settings deciding which files the watcher watches.
+func watchSettings(conf config.Config) []any {
+	return []any{conf.Path, conf.ExcludeDirs, conf.ExcludeFiles, conf.ExcludeExts, conf.ExcludePrefix, conf.IncludeDirs, conf.IncludeFiles}
+}
+
+// applyConfig replaces the session's config and applies what changed: services and processes with new run settings
+// restart if they were running, removed ones stop, added ones start when others run, and the watcher watches the new
+// set of files. Unchanged services keep running, their debugger attached.
+func (gsh *gadgetShell) applyConfig(conf config.Config) {
+	previous := gsh.config
+	applied := make([]string, 0)
+
+	anyRunning := false
+	for _, unit := range gsh.units {
+		anyRunning = anyRunning || unit.isRunning()
+	}
+
+	// units whose settings changed or that are gone stop, those that were running start again with the new settings
+	stopped := make([]managed, 0)
+	restart := make([]string, 0)
+
+	builders := newBuilders(conf)
+	for i, b := range builders {
+		unit := findUnit(buildersAsUnits(gsh.builders), b.name())
+
+		// keep the port found for a service without an address
+		// and the package and variant picked, until the config's change
+		if existing, ok := unit.(*builder); ok && existing.chosenPackage != "" && b.config.BuildPackage == configuredPackage(previous, b.name()) {
+			b.choosePackage(existing.chosenPackage)
+		}
+
+		if existing, ok := unit.(*builder); ok && existing.chosenVariant != "" && b.config.Variant == previous.Variant {
+			b.chooseVariant(existing.chosenVariant)
+		}
+
+		if existing, ok := unit.(*builder); ok && existing.addressAssigned && b.config.Address == "" {
+			b.config.Address = existing.config.Address
+			b.addressAssigned = true
+		}
+
+		if existing, ok := unit.(*builder); ok && reflect.DeepEqual(newRunSettings(existing.config), newRunSettings(b.config)) {
+			existing.mu.Lock()
+			existing.config = b.config
+			existing.mu.Unlock()
+
+			builders[i] = existing
+
+			continue
+		}
+
+		if unit != nil {
+			stopped = append(stopped, unit)
+		}
+
+		if unit != nil && unit.isRunning() || unit == nil && anyRunning {
+			restart = append(restart, b.name())
+		}
+	}
+
+	processes := newProcesses(conf)
+	for i, p := range processes {
+		unit := findUnit(processesAsUnits(gsh.processes), p.name())
+
+		if existing, ok := unit.(*auxProcess); ok && reflect.DeepEqual(existing.config, p.config) {
+			processes[i] = existing
+
+			continue
+		}
+
+		if unit != nil {
+			stopped = append(stopped, unit)
+		}
+
+		if unit != nil && unit.isRunning() || unit == nil && anyRunning {
+			restart = append(restart, p.name())
+		}
+	}
+
+	units := newUnits(conf, builders, processes)
+
+	for _, unit := range gsh.units {
+		if findUnit(units, unit.name()) == nil {
+			stopped = append(stopped, unit)
+			applied = append(applied, "removed "+unit.name())
+		}
+	}
+
+	// stop in the reverse of the start order
+	ordered := make([]managed, 0, len(stopped))
+	for _, unit := range gsh.units {
+		if findUnit(stopped, unit.name()) != nil {
+			ordered = append(ordered, unit)
+		}
+	}
+
+	stopUnits(ordered)
+
+	if !reflect.DeepEqual(previous.Output, conf.Output) || !reflect.DeepEqual(previous.LogFormat, conf.LogFormat) {
+		setLineFormat(conf.Output, conf.LogFormat)
+		applied = append(applied, "output")
+	}
+
+	gsh.config = conf
+	gsh.builders = builders
+	gsh.processes = processes
+	gsh.units = units
+
+	if gsh.watcher != nil && gsh.watcher.isWatching && !reflect.DeepEqual(watchSettings(previous), watchSettings(conf)) {
+		gsh.watcher.endWatch()
+
+		watcher := newWatcher(conf)
+		gsh.watcher = &watcher
+
+		runWatcher(gsh.watcher, gsh)
+		applied = append(applied, "watched files")
+	}
+
+	if len(restart) > 0 {
+		startUnits(gsh.units, gsh.selectUnits(restart))
+		applied = append(applied, "restarted "+strings.Join(restart, ", "))
+	}
+
+	if len(applied) > 0 {
+		cmd.PrintfSuccess("Config reloaded: %v", strings.Join(applied, ", "))
+	} else {
+		cmd.PrintfSuccess("Config reloaded")
+	}
+
+	// settings used when the session starts
+	logFilesChanged := (previous.LogFiles.Enabled || conf.LogFiles.Enabled) && !reflect.DeepEqual(previous.LogFiles, conf.LogFiles)
+	if previous.Output.Scrollback != conf.Output.Scrollback || logFilesChanged || previous.Control != conf.Control {
+		cmd.PrintfWarning("Changes to scrollback, [log_files] and [control] apply when gadget restarts")
+	}
+
+	sessionLogs.marker("gadget", "config reloaded, profile "+describeProfile(conf.Profile))
+	events.publish("config", "gadget", strings.Join(applied, ", "))
+}
+
+// configuredPackage is the build_package the config sets for a service.
+func configuredPackage(conf config.Config, name string) string {
+	for _, serviceConfig := range conf.ServiceConfigs() {
+		if serviceConfig.ServiceName == name || serviceConfig.ServiceName == "" && serviceConfig.Name == name {
+			return serviceConfig.BuildPackage
+		}
+	}
+
+	return ""
+}
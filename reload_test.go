@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"testing"
+)
+
+func TestApplyConfigKeepsUnchangedServices(t *testing.T) {
+	conf := config.Config{
+		Name: "app",
+		Path: t.TempDir(),
+		Services: []config.Service{
+			{Name: "api", Address: "localhost:8080"},
+			{Name: "worker"},
+		},
+		Processes: []config.Process{{Name: "web", Command: "npm run dev"}},
+	}
+
+	builders := newBuilders(conf)
+	processes := newProcesses(conf)
+	gsh := &gadgetShell{
+		config:    conf,
+		builders:  builders,
+		processes: processes,
+		units:     newUnits(conf, builders, processes),
+	}
+
+	changed := conf
+	changed.ExcludeDirs = []string{"vendor"}
+	changed.Services = []config.Service{
+		{Name: "api", Address: "localhost:8081"},
+		{Name: "worker"},
+	}
+
+	gsh.applyConfig(changed)
+
+	if gsh.builders[0] == builders[0] || gsh.builders[0].config.Address != "localhost:8081" {
+		t.Errorf("Expected api to be replaced with its new address")
+	}
+
+	if gsh.builders[1] != builders[1] {
+		t.Errorf("Expected worker to be kept, its run settings didn't change")
+	}
+
+	if gsh.processes[0] != processes[0] {
+		t.Errorf("Expected web to be kept, its config didn't change")
+	}
+
+	if len(gsh.config.ExcludeDirs) != 1 || len(gsh.units) != 3 {
+		t.Errorf("Expected the new config and every unit, got %v units", len(gsh.units))
+	}
+}
@@ -1,24 +1,30 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"github.com/clanko/gadget/cmd"
 	"github.com/clanko/gadget/config"
-	"io"
 	"os"
 	"os/exec"
-	"strings"
+	"sync/atomic"
+	"syscall"
 )
 
 var gadgetCliConfigDir = ".clanko-gadget-cli"
 
 type gadgetShell struct {
-	builder *builder
+	editor *lineEditor
+	// plugin names and their executables
+	plugins   map[string]string
+	builders  []*builder
+	processes []*auxProcess
+	// builders and processes in start order
+	units   []managed
 	watcher *watcher
 	config  config.Config
 }
 
-func newGadgetShell(b *builder, w *watcher, config config.Config) gadgetShell {
+func newGadgetShell(builders []*builder, processes []*auxProcess, units []managed, w *watcher, config config.Config) *gadgetShell {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
@@ -31,33 +37,62 @@ func newGadgetShell(b *builder, w *watcher, config config.Config) gadgetShell {
 		panic(err)
 	}
 
-	gsh := gadgetShell{}
+	gsh := &gadgetShell{}
 	gsh.watcher = w
-	gsh.builder = b
+	gsh.builders = builders
+	gsh.processes = processes
+	gsh.units = units
 	gsh.config = config
+	gsh.plugins = findPlugins()
+	gsh.editor = newLineEditor(home+"/"+gadgetCliConfigDir+"/history", gsh.complete)
+
+	gsh.warnShadowedCommands()
 
 	return gsh
 }
 
-func (gsh gadgetShell) getCommands() map[string]command {
+func (gsh *gadgetShell) getCommands() map[string]command {
 	registeredCommands := make(map[string]command)
 
-	registeredCommands["make"] = makeCommand{}
-	registeredCommands["build"] = buildCommand{&gsh}
-	registeredCommands["run"] = runCommand{&gsh}
-	registeredCommands["debug"] = debugCommand{&gsh}
-	registeredCommands["dev"] = devCommand{&gsh}
-	registeredCommands["watch"] = watchCommand{&gsh}
-	registeredCommands["unwatch"] = unwatchCommand{&gsh}
+	for name, path := range gsh.plugins {
+		registeredCommands[name] = pluginCommand{gsh, name, path}
+	}
+
+	// gadget's own commands take precedence over those defined in gadget.toml, and those over plugins
+	for name, userConfig := range gsh.config.Commands {
+		registeredCommands[name] = userCommand{gsh, name, userConfig}
+	}
+
+	commands := []command{
+		makeCommand{},
+		buildCommand{gsh},
+		runCommand{gsh},
+		debugCommand{gsh},
+		devCommand{gsh},
+		watchCommand{gsh},
+		unwatchCommand{gsh},
+		restartCommand{gsh},
+		logsCommand{gsh},
+		statusCommand{gsh},
+		profileCommand{gsh},
+		pprofCommand{gsh},
+		variantCommand{gsh},
+		coverageCommand{gsh},
+		helpCommand{gsh},
+	}
+
+	for _, registered := range commands {
+		registeredCommands[registered.info().name] = registered
+	}
 
 	return registeredCommands
 }
 
-func (gsh gadgetShell) getCommand(key string) command {
+func (gsh *gadgetShell) getCommand(key string) command {
 	return gsh.getCommands()[key]
 }
 
-func (gsh gadgetShell) hasCommand(key string) bool {
+func (gsh *gadgetShell) hasCommand(key string) bool {
 	for registered := range gsh.getCommands() {
 		if registered == key {
 			return true
@@ -67,63 +102,166 @@ func (gsh gadgetShell) hasCommand(key string) bool {
 	return false
 }
 
-func (gsh gadgetShell) run() {
-	input := bufio.NewScanner(os.Stdin)
-
-	for {
-		printPrompt()
+// selectBuilders returns the builders of the services named in args, or every builder when no service is named.
+func (gsh *gadgetShell) selectBuilders(args []string) []*builder {
+	if len(args) == 0 {
+		return gsh.builders
+	}
 
-		input.Scan()
+	selected := make([]*builder, 0)
+	for _, name := range args {
+		if findUnit(buildersAsUnits(gsh.builders), name) == nil {
+			cmd.PrintfWarning("Unknown service: %v", name)
+		}
+	}
 
-		bin, args := gsh.splitCommand(input.Text())
-		// first check if it's a registered command for gadget
-		if gsh.hasCommand(bin) != false {
-			gsh.getCommand(bin).execute(input, args)
-		} else {
-			// If we don't have a command check if it's a valid shell command
-			command := exec.Command(bin, args...)
-
-			stdout, err := command.Output()
-			if err != nil {
-				// error executing command. print help message
-				cmd.PrintfWarning("Unknown command: " + bin)
-				cmd.PrintfWarning("Enter \"help\" for a list of commands")
-			} else {
-				println(string(stdout))
+	// keep the start order
+	for _, builder := range gsh.builders {
+		for _, name := range args {
+			if builder.name() == name {
+				selected = append(selected, builder)
 			}
 		}
 	}
+
+	return selected
 }
 
-func (gsh gadgetShell) splitCommand(input string) (string, []string) {
-	split := strings.Split(input, " ")
-	var bin string
-	args := make([]string, 0)
-	for i := range split {
-		if i == 0 {
-			bin = split[0]
-		} else {
-			args = append(args, split[i])
+// selectUnits returns the services and processes named in args, or all of them when none is named.
+func (gsh *gadgetShell) selectUnits(args []string) []managed {
+	if len(args) == 0 {
+		return gsh.units
+	}
+
+	selected := make([]managed, 0)
+	for _, name := range args {
+		unit := findUnit(gsh.units, name)
+		if unit == nil {
+			cmd.PrintfWarning("Unknown service or process: %v", name)
+
+			continue
 		}
+
+		selected = append(selected, unit)
 	}
 
-	return bin, args
+	return selected
 }
 
-func printPrompt() {
-	print(cmd.FormatSuccess("gadget->: "))
+func (gsh *gadgetShell) run() {
+	for {
+		line, err := gsh.editor.readLine(promptText())
+		if err != nil {
+			// input ended, gadget keeps running until ^C
+			return
+		}
+
+		gsh.editor.addHistory(line)
+
+		commandMu.Lock()
+		_ = gsh.runLine(line)
+		commandMu.Unlock()
+	}
 }
 
-func printReadCloser(readCloser io.ReadCloser, printFunc func(string)) {
-	reader := bufio.NewReader(readCloser)
-	line, err := reader.ReadString('\n')
-	for err == nil {
-		if line != "" {
-			printFunc(line)
+// runLine runs a line entered in the shell: a gadget command, or else a shell command. The error tells whether the
+// line failed, it's been printed already.
+func (gsh *gadgetShell) runLine(line string) error {
+	bin, args, err := gsh.splitCommand(line)
+	if err != nil {
+		cmd.PrintfWarning("%v", err)
+
+		return err
+	}
 
-			return
+	if bin == "" {
+		return nil
+	}
+
+	// first check if it's a registered command for gadget
+	if gsh.hasCommand(bin) != false {
+		registered := gsh.getCommand(bin)
+
+		err := registered.info().validateArgs(args)
+		if err != nil {
+			cmd.PrintfWarning("Usage: %v", registered.info().usage())
+			cmd.PrintfWarning("Enter \"help %v\" for more", bin)
+
+			return err
+		}
+
+		registered.execute(gsh.editor, args)
+
+		return nil
+	}
+
+	// If we don't have a command check if it's a valid shell command
+	return runPassthrough(bin, args)
+}
+
+// set while a shell command runs in the foreground, ^C is meant for it rather than gadget
+var passthroughRunning atomic.Bool
+
+// runPassthrough runs a command that isn't a gadget command, attached to the terminal so its output streams as it's
+// printed and interactive tools can read input. ^C goes to the command, which shares gadget's process group.
+func runPassthrough(bin string, args []string) error {
+	return runAttached(bin, exec.Command(bin, args...))
+}
+
+// runAttached runs command attached to the terminal, reporting how it failed.
+func runAttached(bin string, command *exec.Cmd) error {
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	passthroughRunning.Store(true)
+	defer passthroughRunning.Store(false)
+
+	err := command.Run()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		// error executing command. print help message
+		cmd.PrintfWarning("Unknown command: " + bin)
+		cmd.PrintfWarning("Enter \"help\" for a list of commands")
+
+	case errors.As(err, &exitErr):
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			cmd.PrintfWarning("%v was terminated by %v", bin, status.Signal())
+		} else {
+			cmd.PrintfWarning("%v exited with status %v", bin, exitErr.ExitCode())
 		}
 
-		line, err = reader.ReadString('\n')
+	default:
+		cmd.PrintfDanger("%v", err)
+	}
+
+	return err
+}
+
+// splitCommand splits a command line into the command and its arguments, the way a shell does.
+func (gsh *gadgetShell) splitCommand(input string) (string, []string, error) {
+	words, err := splitArgs(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(words) == 0 {
+		return "", []string{}, nil
 	}
+
+	return words[0], words[1:], nil
+}
+
+func printPrompt() {
+	terminal.showPrompt()
+}
+
+func promptText() string {
+	return cmd.FormatSuccess("gadget->: ")
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"net"
+	"net/http"
+	"time"
+)
+
+const readyTimeout = 30 * time.Second
+
+// managed is something gadget starts and stops for the session: a service, or an auxiliary process.
+type managed interface {
+	name() string
+	dependencies() []string
+	start()
+	stop()
+	isRunning() bool
+	waitReady(timeout time.Duration) bool
+}
+
+// newUnits puts the builders and processes in start order.
+func newUnits(conf config.Config, builders []*builder, processes []*auxProcess) []managed {
+	all := make([]managed, 0, len(builders)+len(processes))
+	for _, process := range processes {
+		all = append(all, process)
+	}
+
+	for _, builder := range builders {
+		all = append(all, builder)
+	}
+
+	order, err := conf.StartOrder()
+	if err != nil {
+		return all
+	}
+
+	units := make([]managed, 0, len(all))
+	for _, name := range order {
+		unit := findUnit(all, name)
+		if unit != nil {
+			units = append(units, unit)
+		}
+	}
+
+	// anything renamed by flags after the config loaded
+	for _, unit := range all {
+		if findUnit(units, unit.name()) == nil {
+			units = append(units, unit)
+		}
+	}
+
+	return units
+}
+
+// startUnits starts the selected units in dependency order. A unit only starts once the units it depends on are
+// ready.
+func startUnits(units []managed, selected []managed) {
+	for _, unit := range units {
+		if findUnit(selected, unit.name()) == nil {
+			continue
+		}
+
+		for _, dependency := range unit.dependencies() {
+			dependencyUnit := findUnit(units, dependency)
+			if dependencyUnit == nil {
+				continue
+			}
+
+			if !dependencyUnit.isRunning() {
+				cmd.PrintfWarning("dependency %v isn't running, starting %v anyway", dependency, unit.name())
+			} else if !dependencyUnit.waitReady(readyTimeout) {
+				cmd.PrintfWarning("%v wasn't ready after %v, starting %v anyway", dependency, readyTimeout, unit.name())
+			}
+		}
+
+		unit.start()
+	}
+}
+
+// stopUnits stops the units in the reverse of their start order, so nothing loses a dependency while running.
+func stopUnits(units []managed) {
+	for i := len(units) - 1; i >= 0; i-- {
+		if units[i].isRunning() {
+			cmd.PrintfInfo("Ending %v...", units[i].name())
+		}
+
+		units[i].stop()
+	}
+}
+
+func findUnit(units []managed, name string) managed {
+	for _, unit := range units {
+		if unit.name() == name {
+			return unit
+		}
+	}
+
+	return nil
+}
+
+func buildersAsUnits(builders []*builder) []managed {
+	units := make([]managed, 0, len(builders))
+	for _, builder := range builders {
+		units = append(units, builder)
+	}
+
+	return units
+}
+
+func processesAsUnits(processes []*auxProcess) []managed {
+	units := make([]managed, 0, len(processes))
+	for _, process := range processes {
+		units = append(units, process)
+	}
+
+	return units
+}
+
+func (b *builder) dependencies() []string {
+	return b.config.DependsOn
+}
+
+// start rebuilds the service and runs it with the debugger attached.
+func (b *builder) start() {
+	b.runBuildDebug()
+}
+
+func (b *builder) stop() {
+	b.stopRunningProcesses()
+}
+
+func (b *builder) isRunning() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.runningBinary != nil && b.runningBinary.Process != nil && b.exitState == ""
+}
+
+// restart runs the last built binary and debugger again, without rebuilding. When the binary can't start, neither does
+// the debugger.
+func (b *builder) restart() {
+	b.stopRunningProcesses()
+
+	if b.runBinary() == nil {
+		b.runDebugger()
+	}
+}
+
+// waitReady waits for the running binary to answer its health check, or to accept connections on its address when
+// there's no health check.
+func (b *builder) waitReady(timeout time.Duration) bool {
+	if !b.isRunning() {
+		return false
+	}
+
+	ready := waitForAddress(b.config.Address, b.config.HealthCheck, timeout)
+	if ready && verbose > 0 {
+		cmd.PrintfInfo("%v is ready", b.name())
+	}
+
+	return ready
+}
+
+// waitForAddress waits for a health check path on address to respond with a 2xx status, or for address to accept
+// connections when healthCheck is empty.
+func waitForAddress(address string, healthCheck string, timeout time.Duration) bool {
+	maxWait := time.NewTimer(timeout)
+	increment := time.NewTicker(250 * time.Millisecond)
+
+	defer maxWait.Stop()
+	defer increment.Stop()
+
+	client := http.Client{Timeout: time.Second}
+
+	for {
+		if isReady(&client, address, healthCheck) {
+			return true
+		}
+
+		select {
+		case <-maxWait.C:
+			return false
+
+		case <-increment.C:
+		}
+	}
+}
+
+func isReady(client *http.Client, address string, healthCheck string) bool {
+	if healthCheck == "" {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err != nil {
+			return false
+		}
+
+		_ = conn.Close()
+
+		return true
+	}
+
+	response, err := client.Get("http://" + address + healthCheck)
+	if err != nil {
+		return false
+	}
+
+	_ = response.Body.Close()
+
+	return response.StatusCode >= 200 && response.StatusCode < 300
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/clanko/gadget/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeUnit records what's done to it in a log shared by the units of a test.
+type fakeUnit struct {
+	unitName  string
+	dependsOn []string
+	running   bool
+	log       *[]string
+}
+
+func (u *fakeUnit) name() string {
+	return u.unitName
+}
+
+func (u *fakeUnit) dependencies() []string {
+	return u.dependsOn
+}
+
+func (u *fakeUnit) start() {
+	*u.log = append(*u.log, "start "+u.unitName)
+	u.running = true
+}
+
+func (u *fakeUnit) stop() {
+	*u.log = append(*u.log, "stop "+u.unitName)
+	u.running = false
+}
+
+func (u *fakeUnit) isRunning() bool {
+	return u.running
+}
+
+func (u *fakeUnit) waitReady(timeout time.Duration) bool {
+	*u.log = append(*u.log, "wait "+u.unitName)
+
+	return u.running
+}
+
+func newFakeUnits(log *[]string) []managed {
+	return []managed{
+		&fakeUnit{unitName: "db", log: log},
+		&fakeUnit{unitName: "api", dependsOn: []string{"db"}, log: log},
+		&fakeUnit{unitName: "web", dependsOn: []string{"api", "cdn"}, log: log},
+	}
+}
+
+func TestStartUnits(t *testing.T) {
+	var log []string
+	units := newFakeUnits(&log)
+
+	startUnits(units, units)
+
+	expected := []string{"start db", "wait db", "start api", "wait api", "start web"}
+	if reflect.DeepEqual(log, expected) == false {
+		t.Errorf("Expected %q, got %q", expected, log)
+	}
+
+	// a dependency that isn't running isn't waited for
+	log = nil
+	units = newFakeUnits(&log)
+
+	startUnits(units, units[1:2])
+
+	expected = []string{"start api"}
+	if reflect.DeepEqual(log, expected) == false {
+		t.Errorf("Expected %q, got %q", expected, log)
+	}
+}
+
+func TestStopUnits(t *testing.T) {
+	var log []string
+	units := newFakeUnits(&log)
+
+	stopUnits(units)
+
+	expected := []string{"stop web", "stop api", "stop db"}
+	if reflect.DeepEqual(log, expected) == false {
+		t.Errorf("Expected %q, got %q", expected, log)
+	}
+}
+
+func TestRestartWithoutBinary(t *testing.T) {
+	app := &builder{config: config.Config{Name: "missing", Path: t.TempDir(), Address: "localhost:8080", Variant: "debug"}}
+
+	// not built yet, so there's nothing to run or attach the debugger to
+	app.restart()
+
+	if app.isRunning() || app.runningBinary != nil || app.debugger != nil {
+		t.Errorf("Expected nothing to run without a binary")
+	}
+}
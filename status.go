@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionStatus is the state of the session, shown by the status command and answered by the control API.
+type sessionStatus struct {
+	GadgetVersion string `json:"gadget_version"`
+	GoVersion     string `json:"go_version"`
+	// the config file loaded, empty when there's none and defaults are used
+	Config string `json:"config"`
+	// the [profile.<name>] overlaid on the config, empty for none
+	Profile string        `json:"profile,omitempty"`
+	Watcher watcherStatus `json:"watcher"`
+	Units   []unitStatus  `json:"units"`
+}
+
+type watcherStatus struct {
+	IsWatching  bool       `json:"is_watching"`
+	WatchedDirs int        `json:"watched_dirs"`
+	LastTrigger *time.Time `json:"last_trigger,omitempty"`
+	LastChanged []string   `json:"last_changed,omitempty"`
+}
+
+// unitStatus is the state of a service or auxiliary process.
+type unitStatus struct {
+	Name string `json:"name"`
+	// service or process
+	Kind    string `json:"kind"`
+	Running bool   `json:"running"`
+	Pid     int    `json:"pid,omitempty"`
+	Address string `json:"address,omitempty"`
+	// main package a service builds
+	Package string `json:"package,omitempty"`
+	// build variant of a service, like race
+	Variant       string     `json:"variant,omitempty"`
+	Started       *time.Time `json:"started,omitempty"`
+	UptimeSeconds int64      `json:"uptime_seconds,omitempty"`
+	// how the last run exited, e.g. "exit status 1"
+	ExitState   string       `json:"exit_state,omitempty"`
+	DebuggerPid int          `json:"debugger_pid,omitempty"`
+	DebugPort   int          `json:"debug_port,omitempty"`
+	LastBuild   *buildStatus `json:"last_build,omitempty"`
+	Restarts    int          `json:"restarts,omitempty"`
+}
+
+type buildStatus struct {
+	OK         bool      `json:"ok"`
+	DurationMs int64     `json:"duration_ms"`
+	Finished   time.Time `json:"finished"`
+}
+
+func (gsh *gadgetShell) status() sessionStatus {
+	status := sessionStatus{
+		GadgetVersion: GADGET_VERSION,
+		GoVersion:     runtime.Version(),
+		Profile:       gsh.config.Profile,
+		Units:         make([]unitStatus, 0, len(gsh.units)),
+	}
+
+	if _, err := os.Stat(configFile); err == nil {
+		status.Config = configFile
+	}
+
+	if gsh.watcher != nil && gsh.watcher.isWatching {
+		status.Watcher.IsWatching = true
+		status.Watcher.WatchedDirs = gsh.watcher.watchedDirs()
+
+		lastTrigger, lastChanged := gsh.watcher.lastChange()
+		if !lastTrigger.IsZero() {
+			status.Watcher.LastTrigger = &lastTrigger
+			status.Watcher.LastChanged = lastChanged
+		}
+	}
+
+	for _, unit := range gsh.units {
+		switch unit := unit.(type) {
+		case *builder:
+			status.Units = append(status.Units, unit.status())
+		case *auxProcess:
+			status.Units = append(status.Units, unit.status())
+		}
+	}
+
+	return status
+}
+
+func (b *builder) status() unitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	unit := unitStatus{
+		Name:      b.name(),
+		Kind:      "service",
+		Address:   b.config.Address,
+		Package:   b.config.BuildPackage,
+		Variant:   b.config.Variant,
+		ExitState: b.exitState,
+		DebugPort: b.port,
+	}
+
+	if b.runningBinary != nil && b.runningBinary.Process != nil {
+		unit.Running = b.exitState == ""
+		unit.Started = &b.startedAt
+	}
+
+	if unit.Running {
+		unit.Pid = b.runningBinary.Process.Pid
+		unit.UptimeSeconds = int64(time.Since(b.startedAt).Seconds())
+		unit.DebuggerPid = b.debuggerPid
+	}
+
+	if !b.lastBuild.finishedAt.IsZero() {
+		unit.LastBuild = &buildStatus{
+			OK:         b.lastBuild.ok,
+			DurationMs: b.lastBuild.duration.Milliseconds(),
+			Finished:   b.lastBuild.finishedAt,
+		}
+	}
+
+	return unit
+}
+
+func (p *auxProcess) status() unitStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	unit := unitStatus{
+		Name:      p.name(),
+		Kind:      "process",
+		Running:   p.running,
+		Address:   p.config.Address,
+		ExitState: p.exitState,
+		Restarts:  p.restarts,
+	}
+
+	if !p.startedAt.IsZero() {
+		unit.Started = &p.startedAt
+	}
+
+	if p.running {
+		unit.Pid = p.command.Process.Pid
+		unit.UptimeSeconds = int64(time.Since(p.startedAt).Seconds())
+	}
+
+	return unit
+}
+
+// formatStatus renders a status for people.
+func formatStatus(status sessionStatus) string {
+	var buffer bytes.Buffer
+
+	writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(writer, "Gadget\t%v, %v\n", status.GadgetVersion, status.GoVersion)
+
+	if status.Config != "" && status.Profile != "" {
+		fmt.Fprintf(writer, "Config\t%v, profile %v\n", status.Config, status.Profile)
+	} else if status.Config != "" {
+		fmt.Fprintf(writer, "Config\t%v\n", status.Config)
+	} else {
+		fmt.Fprintf(writer, "Config\tnone, using defaults\n")
+	}
+
+	fmt.Fprintf(writer, "Watcher\t%v\n", formatWatcherStatus(status.Watcher))
+
+	for _, unit := range status.Units {
+		fmt.Fprintf(writer, "\n%v\t%v, %v\n", unit.Name, unit.Kind, formatUnitState(unit))
+
+		if unit.Address != "" {
+			fmt.Fprintf(writer, "  address\t%v\n", unit.Address)
+		}
+
+		if unit.Package != "" {
+			fmt.Fprintf(writer, "  package\t%v\n", unit.Package)
+		}
+
+		if unit.Variant != "" {
+			fmt.Fprintf(writer, "  variant\t%v\n", unit.Variant)
+		}
+
+		if unit.DebuggerPid != 0 {
+			fmt.Fprintf(writer, "  debugger\tpid %v, port %v\n", unit.DebuggerPid, unit.DebugPort)
+		}
+
+		if unit.LastBuild != nil {
+			result := "failed"
+			if unit.LastBuild.OK {
+				result = "ok"
+			}
+
+			duration := time.Duration(unit.LastBuild.DurationMs) * time.Millisecond
+			fmt.Fprintf(writer, "  last build\t%v in %v, at %v\n", result, duration, unit.LastBuild.Finished.Format(time.TimeOnly))
+		}
+
+		if unit.Restarts > 0 {
+			fmt.Fprintf(writer, "  restarts\t%v\n", unit.Restarts)
+		}
+	}
+
+	_ = writer.Flush()
+
+	return buffer.String()
+}
+
+func formatWatcherStatus(watcher watcherStatus) string {
+	if !watcher.IsWatching {
+		return "not watching"
+	}
+
+	state := fmt.Sprintf("watching %v directories", watcher.WatchedDirs)
+	if watcher.LastTrigger == nil {
+		return state + ", no changes yet"
+	}
+
+	changed := ""
+	if len(watcher.LastChanged) > 0 {
+		changed = " by " + watcher.LastChanged[0]
+		if len(watcher.LastChanged) > 1 {
+			changed += fmt.Sprintf(" and %v more", len(watcher.LastChanged)-1)
+		}
+	}
+
+	return state + ", last triggered at " + watcher.LastTrigger.Format(time.TimeOnly) + changed
+}
+
+func formatUnitState(unit unitStatus) string {
+	switch {
+	case unit.Running:
+		uptime := time.Duration(unit.UptimeSeconds) * time.Second
+
+		return fmt.Sprintf("running, pid %v, up %v", unit.Pid, uptime)
+
+	case unit.ExitState != "":
+		return "exited: " + unit.ExitState
+
+	default:
+		return "not started"
+	}
+}
+
+// statusCommand shows what the session is doing.
+type statusCommand struct {
+	gsh *gadgetShell
+}
+
+func (command statusCommand) info() commandInfo {
+	return commandInfo{
+		name:     "status",
+		synopsis: "Shows the state of services, processes, the watcher and the last builds",
+		args:     "[--json]",
+		details:  "--json prints the status as JSON, the way the control API answers it.",
+		minArgs:  0,
+		maxArgs:  1,
+	}
+}
+
+func (command statusCommand) execute(input lineReader, args []string) {
+	status := command.gsh.status()
+
+	if len(args) == 0 {
+		cmd.Write(formatStatus(status))
+
+		return
+	}
+
+	if args[0] != "--json" && args[0] != "-json" {
+		cmd.PrintfWarning("Usage: %v", command.info().usage())
+
+		return
+	}
+
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		cmd.PrintfDanger("%v", err)
+
+		return
+	}
+
+	cmd.Write(strings.TrimSpace(string(content)) + "\n")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatStatus(t *testing.T) {
+	triggered := time.Date(2024, 5, 1, 10, 4, 5, 0, time.UTC)
+
+	status := sessionStatus{
+		GadgetVersion: "0.1.1",
+		GoVersion:     "go1.23.0",
+		Watcher: watcherStatus{
+			IsWatching:  true,
+			WatchedDirs: 12,
+			LastTrigger: &triggered,
+			LastChanged: []string{"/app/main.go", "/app/api/handler.go"},
+		},
+		Units: []unitStatus{
+			{Name: "api", Kind: "service", Running: true, Pid: 1234, UptimeSeconds: 90, DebuggerPid: 1240, DebugPort: 3811},
+			{Name: "web", Kind: "process", ExitState: "exit status 1"},
+		},
+	}
+
+	formatted := formatStatus(status)
+
+	expected := []string{
+		"none, using defaults",
+		"watching 12 directories, last triggered at 10:04:05 by /app/main.go and 1 more",
+		"running, pid 1234, up 1m30s",
+		"pid 1240, port 3811",
+		"exited: exit status 1",
+	}
+
+	for _, part := range expected {
+		if !strings.Contains(formatted, part) {
+			t.Errorf("Expected status to contain %q, got\n%v", part, formatted)
+		}
+	}
+}
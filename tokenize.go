@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// splitArgs splits a command line into words like a shell does. Words are separated by spaces and tabs. Single
+// quotes keep everything literal. Double quotes keep spaces and expand variables, with \ escaping ", \, $ and `.
+// Outside quotes \ escapes any character, $VAR and ${VAR} expand to the environment, and a leading ~ to the home
+// directory. Expanded values aren't split into more words.
+func splitArgs(input string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	// a word can be empty, like ""
+	inWord := false
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case r == '\'':
+			end := indexRune(runes, '\'', i+1)
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+					i++
+					word.WriteRune(runes[i])
+				} else if runes[i] == '$' {
+					i = expandVariable(runes, i, &word)
+				} else {
+					word.WriteRune(runes[i])
+				}
+			}
+
+			if i >= len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+
+			inWord = true
+
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("nothing to escape at the end of the line")
+			}
+
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+
+		case r == '$':
+			// like in a shell, an unquoted expansion to nothing doesn't make a word
+			length := word.Len()
+			i = expandVariable(runes, i, &word)
+			inWord = inWord || word.Len() > length
+
+		case r == '~' && !inWord && (i+1 == len(runes) || runes[i+1] == '/' || runes[i+1] == ' ' || runes[i+1] == '\t'):
+			home, err := os.UserHomeDir()
+			if err != nil {
+				home = "~"
+			}
+
+			word.WriteString(home)
+			inWord = true
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// expandVariable writes the value of $NAME or ${NAME} starting at runes[start] and returns the index of its last
+// rune. A $ not followed by a name is kept as it is.
+func expandVariable(runes []rune, start int, word *strings.Builder) int {
+	if start+1 < len(runes) && runes[start+1] == '{' {
+		end := indexRune(runes, '}', start+2)
+		if end < 0 {
+			word.WriteRune('$')
+
+			return start
+		}
+
+		word.WriteString(os.Getenv(string(runes[start+2 : end])))
+
+		return end
+	}
+
+	end := start + 1
+	for end < len(runes) && isNameRune(runes[end], end == start+1) {
+		end++
+	}
+
+	if end == start+1 {
+		word.WriteRune('$')
+
+		return start
+	}
+
+	word.WriteString(os.Getenv(string(runes[start+1 : end])))
+
+	return end - 1
+}
+
+func isNameRune(r rune, first bool) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (!first && r >= '0' && r <= '9')
+}
+
+func indexRune(runes []rune, r rune, from int) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
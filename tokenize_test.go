@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	t.Setenv("GADGET_TEST_VALUE", "a b")
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"go  test\t./...":              {"go", "test", "./..."},
+		`echo "hello world" 'it''s'`:   {"echo", "hello world", "its"},
+		`echo 'no $GADGET_TEST_VALUE'`: {"echo", "no $GADGET_TEST_VALUE"},
+		`echo $GADGET_TEST_VALUE`:      {"echo", "a b"},
+		`echo "${GADGET_TEST_VALUE}!"`: {"echo", "a b!"},
+		`echo a\ b "\"quoted\"" ""`:    {"echo", "a b", `"quoted"`, ""},
+		`ls ~/bin ~x $ 5$`:             {"ls", home + "/bin", "~x", "$", "5$"},
+		`echo $GADGET_NONE foo`:        {"echo", "foo"},
+		`"$GADGET_NONE" $GADGET_NONE.`: {"", "."},
+		"":                             {},
+	}
+
+	for input, expected := range tests {
+		words, err := splitArgs(input)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", input, err)
+
+			continue
+		}
+
+		if reflect.DeepEqual(words, expected) == false {
+			t.Errorf("Expected %q to split into %q, got %q", input, expected, words)
+		}
+	}
+
+	for _, input := range []string{`echo "unterminated`, `echo 'unterminated`, `echo \`} {
+		_, err := splitArgs(input)
+		if err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// commands defined in gadget.toml can run each other, but not forever
+const maxUserCommandDepth = 10
+
+var userCommandDepth atomic.Int32
+
+// userCommand is a command defined in the [commands] section of gadget.toml.
+type userCommand struct {
+	gsh    *gadgetShell
+	name   string
+	config config.Command
+}
+
+// commandData are the values steps of a userCommand can use.
+type commandData struct {
+	Address   string
+	DebugPort int
+	Path      string
+	Name      string
+	Args      []string
+	Services  map[string]serviceData
+}
+
+type serviceData struct {
+	Address   string
+	DebugPort int
+}
+
+func (command userCommand) info() commandInfo {
+	synopsis := command.config.Description
+	if synopsis == "" {
+		synopsis = "Runs " + strings.Join(command.config.Steps, ", then ")
+	}
+
+	return commandInfo{
+		name:     command.name,
+		synopsis: synopsis,
+		args:     command.config.Args,
+		details:  "Defined in gadget.toml. Steps:\n" + strings.Join(command.config.Steps, "\n"),
+		minArgs:  command.config.MinArgs,
+		maxArgs:  -1,
+	}
+}
+
+func (command userCommand) execute(input lineReader, args []string) {
+	if userCommandDepth.Add(1) > maxUserCommandDepth {
+		userCommandDepth.Add(-1)
+		cmd.PrintfDanger("%v: commands in gadget.toml are calling each other too deep", command.name)
+
+		return
+	}
+
+	defer userCommandDepth.Add(-1)
+
+	for i, step := range command.config.Steps {
+		// earlier steps may have started the app on a new address
+		line, err := renderStep(step, command.data(args))
+		if err != nil {
+			cmd.PrintfDanger("%v: step %v: %v", command.name, i+1, err)
+
+			return
+		}
+
+		if verbose > 0 {
+			cmd.PrintfInfo("%v: %v", command.name, line)
+		}
+
+		err = command.gsh.runLine(line)
+		if err != nil {
+			cmd.PrintfWarning("%v stopped at step %v", command.name, i+1)
+			events.publish("command_failed", command.name, fmt.Sprintf("%v stopped at step %v", command.name, i+1))
+
+			return
+		}
+	}
+}
+
+func (command userCommand) data(args []string) commandData {
+	data := commandData{
+		Path:     command.gsh.config.Path,
+		Name:     command.gsh.config.Name,
+		Args:     args,
+		Services: make(map[string]serviceData),
+	}
+
+	for i, builder := range command.gsh.builders {
+		service := serviceData{
+			Address:   builder.config.Address,
+			DebugPort: builder.port,
+		}
+
+		data.Services[builder.name()] = service
+
+		if i == 0 {
+			data.Address = service.Address
+			data.DebugPort = service.DebugPort
+		}
+	}
+
+	return data
+}
+
+// renderStep fills in a step's template. {{arg N}} is the Nth argument, counting from 1, or empty when it wasn't
+// given.
+func renderStep(step string, data commandData) (string, error) {
+	functions := template.FuncMap{
+		"arg": func(n int) string {
+			if n < 1 || n > len(data.Args) {
+				return ""
+			}
+
+			return data.Args[n-1]
+		},
+		// quote keeps a value a single word when the step is split into arguments
+		"quote": func(value string) string {
+			return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+		},
+	}
+
+	parsed, err := template.New("step").Funcs(functions).Option("missingkey=error").Parse(step)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+
+	err = parsed.Execute(&rendered, data)
+	if err != nil {
+		var execErr template.ExecError
+		if errors.As(err, &execErr) {
+			return "", fmt.Errorf("%v", execErr.Err)
+		}
+
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+// warnShadowedCommands points out commands in gadget.toml named like one of gadget's, which can't be run.
+func (gsh *gadgetShell) warnShadowedCommands() {
+	registered := gsh.getCommands()
+	for name := range gsh.config.Commands {
+		if _, ok := registered[name].(userCommand); !ok {
+			cmd.PrintfWarning("Command %q in gadget.toml is named like a gadget command and won't be used", name)
+		}
+	}
+}
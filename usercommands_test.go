@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderStep(t *testing.T) {
+	data := commandData{
+		Address: "localhost:8090",
+		Args:    []string{"10", "it's"},
+		Services: map[string]serviceData{
+			"worker": {Address: "localhost:8091"},
+		},
+	}
+
+	rendered, err := renderStep(`curl http://{{.Address}}/seed?count={{arg 1}} -d {{quote (arg 2)}}{{arg 3}}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `curl http://localhost:8090/seed?count=10 -d 'it'\''s'`
+	if rendered != expected {
+		t.Errorf("Expected %q, got %q", expected, rendered)
+	}
+
+	words, err := splitArgs(rendered)
+	if err != nil || words[len(words)-1] != "it's" {
+		t.Errorf("Expected a quoted argument to stay one word, got %q", words)
+	}
+
+	rendered, err = renderStep(`{{(index .Services "worker").Address}}`, data)
+	if err != nil || rendered != "localhost:8091" {
+		t.Errorf("Expected the worker's address, got %q %v", rendered, err)
+	}
+
+	_, err = renderStep(`{{.Missing}}`, data)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown value")
+	}
+}
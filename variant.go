@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/clanko/gadget/cmd"
+	"github.com/clanko/gadget/config"
+	"strings"
+)
+
+// variantCommand switches how services are built, e.g. with the race detector, without editing gadget.toml.
+type variantCommand struct {
+	gsh *gadgetShell
+}
+
+func (command variantCommand) info() commandInfo {
+	return commandInfo{
+		name:     "variant",
+		synopsis: "Lists the build variants, or switches services to one",
+		args:     "[debug | race | cover | release] [service...]",
+		details: "Without a variant, lists them and marks those in use. With one, the services, or all of them, build " +
+			"with it until variant changes in gadget.toml, and those running are rebuilt. debug builds without " +
+			"optimizations for the debugger, race with the race detector, cover collects coverage for the coverage " +
+			"command, and release builds optimized and runs without a debugger.",
+		minArgs: 0,
+		maxArgs: -1,
+	}
+}
+
+func (command variantCommand) execute(input lineReader, args []string) {
+	gsh := command.gsh
+
+	if len(args) == 0 {
+		for _, name := range config.VariantNames {
+			users := make([]string, 0)
+			for _, builder := range gsh.builders {
+				if builder.config.Variant == name {
+					users = append(users, builder.name())
+				}
+			}
+
+			switch {
+			case len(users) == 0:
+				cmd.Write("  " + name + "\n")
+			case len(gsh.builders) == 1:
+				cmd.PrintfSuccess("* %v", name)
+			default:
+				cmd.PrintfSuccess("* %v (%v)", name, strings.Join(users, ", "))
+			}
+		}
+
+		return
+	}
+
+	variant := args[0]
+	if !config.IsVariant(variant) {
+		cmd.PrintfWarning("Unknown variant %v, use one of %v", variant, strings.Join(config.VariantNames, ", "))
+
+		return
+	}
+
+	running := make([]managed, 0)
+	for _, builder := range gsh.selectBuilders(args[1:]) {
+		// stop with the variant it runs, the cover variant exits gracefully to write its coverage
+		if builder.isRunning() {
+			builder.stop()
+			running = append(running, builder)
+		}
+
+		builder.chooseVariant(variant)
+		cmd.PrintfSuccess("%v builds with the %v variant until variant changes in %v", builder.name(), variant, config.FileName)
+	}
+
+	startUnits(gsh.units, running)
+}
+
+// chooseVariant builds with another variant than the config's for the rest of the session, or until the config's
+// variant changes.
+func (b *builder) chooseVariant(variant string) {
+	b.config.Variant = variant
+	b.chosenVariant = variant
+}
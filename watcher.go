@@ -14,13 +14,16 @@ import (
 
 type watcher struct {
 	fsWatcher   *fsnotify.Watcher
-	onEvent     func()
+	onEvent     func(changed []string)
 	config      config.Config
 	watchPaths  []string
 	fsWatching  []string
 	pauseEvents bool
 	mu          sync.Mutex
 	isWatching  bool
+	// when changes last triggered a rebuild, and what changed
+	lastTrigger time.Time
+	lastChanged []string
 }
 
 func newWatcher(conf config.Config) watcher {
@@ -79,6 +82,16 @@ func (w *watcher) isExcluded(path string) bool {
 		return true
 	}
 
+	// config changes are reloaded, not rebuilt
+	if path == configFile || path == config.LocalFile(configFile) {
+		return true
+	}
+
+	// nor should saving profiles rebuild, the first capture creates the directory and its parents
+	if dir := w.config.Pprof.Dir; dir != "" && (path == dir || isParentDir(path, dir) || isParentDir(dir, path)) {
+		return true
+	}
+
 	// included files will not be excluded
 	for _, file := range w.config.IncludeFiles {
 		if file == path {
@@ -165,7 +178,9 @@ func (w *watcher) watchDir(dir string) {
 
 	if !isWatching {
 		err = w.fsWatcher.Add(dir)
+		w.mu.Lock()
 		w.fsWatching = append(w.fsWatching, dir)
+		w.mu.Unlock()
 
 		if err != nil {
 			panic(cmd.FormatDanger("%q: %s", dir, err))
@@ -183,9 +198,10 @@ func (w *watcher) watchDir(dir string) {
 
 func (w *watcher) watchLoop() {
 	var (
-		wait   = 500 * time.Millisecond
-		mu     sync.Mutex
-		timers = make(map[string]*time.Timer)
+		wait    = 500 * time.Millisecond
+		mu      sync.Mutex
+		timers  = make(map[string]*time.Timer)
+		changed = make(map[string]bool)
 	)
 
 	for {
@@ -230,6 +246,7 @@ func (w *watcher) watchLoop() {
 			}
 
 			mu.Lock()
+			changed[e.Name] = true
 			modifiedTimer, ok := timers["fileModified"]
 			mu.Unlock()
 
@@ -243,12 +260,22 @@ func (w *watcher) watchLoop() {
 					isPaused := w.pauseEvents
 					w.mu.Unlock()
 
+					mu.Lock()
+					changedPaths := make([]string, 0, len(changed))
+					for path := range changed {
+						changedPaths = append(changedPaths, path)
+					}
+					clear(changed)
+					mu.Unlock()
+
 					if isPaused == false {
 						w.mu.Lock()
 						w.pauseEvents = true
+						w.lastTrigger = time.Now()
+						w.lastChanged = changedPaths
 						w.mu.Unlock()
 
-						w.onEvent()
+						w.onEvent(changedPaths)
 
 						w.mu.Lock()
 						w.pauseEvents = false
@@ -271,6 +298,22 @@ func (w *watcher) watchLoop() {
 	}
 }
 
+// watchedDirs is the number of directories watched for changes.
+func (w *watcher) watchedDirs() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.fsWatching)
+}
+
+// lastChange is when changes last triggered a rebuild, and the changed paths. The time is zero before any change.
+func (w *watcher) lastChange() (time.Time, []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lastTrigger, w.lastChanged
+}
+
 func (w *watcher) endWatch() {
 	if w.isWatching {
 		err := w.fsWatcher.Close()
@@ -299,3 +342,8 @@ func getNestedPaths(root string) ([]string, error) {
 	})
 	return dirs, err
 }
+
+// isParentDir reports whether dir contains path.
+func isParentDir(dir string, path string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}